@@ -9,13 +9,15 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"reflect"
 )
 
 type App struct {
@@ -25,7 +27,37 @@ type App struct {
 	jenkinsAPI    *JenkinsAPI
 	cli           *gocli.CLI
 	cache         Cache
-	wg            sync.WaitGroup
+	events        *EventBus
+	// publishedStatuses keeps last status sent per commit and context, it is
+	// guarded by the cache lock
+	publishedStatuses map[string]string
+	// skippedTriggers keeps conditions of triggers skipped for drafts, it is
+	// guarded by the cache lock
+	skippedTriggers map[string][]string
+	// quietKeys are pull requests being bootstrapped, their labels and
+	// statuses are published once the graph is built. Guarded by the cache
+	// lock
+	quietKeys     map[string]int
+	mergeTrains   *MergeTrains
+	githubAppAuth *GitHubAppAuth
+	// bootstrapFailed keeps repositories that failed to bootstrap and are
+	// being retried in the background
+	bootstrapFailed   map[string]string
+	bootstrapRetrying bool
+	bootstrapMu       sync.Mutex
+	// repositories are the tracked ones, guarded by the cache lock
+	repositories map[string]bool
+	// repositoryAliases maps old names of renamed repositories to the new
+	// ones as DependsOn lines keep the old name, guarded by the cache lock
+	repositoryAliases    map[string]string
+	reconcileMu          sync.Mutex
+	reconcileCorrections map[string]uint64
+	reconcileLastRun     time.Time
+	startedAt            time.Time
+	// deliveries keeps guids of processed webhook deliveries
+	deliveries   map[string]time.Time
+	deliveriesMu sync.Mutex
+	wg           sync.WaitGroup
 }
 
 func (app *App) printIteration(i int, rc int) {
@@ -42,9 +74,27 @@ func (app *App) getCrumbAndSleep(u string, t string, rd int) (string, error) {
 	return crumb, nil
 }
 
+// replacePathWithRepoAndNum fills endpoint path placeholders. Head values point
+// to the fork when pull request was opened from one. Caller must hold the
+// cache lock.
 func (app *App) replacePathWithRepoAndNum(p string, r string, n int) string {
 	s := strings.ReplaceAll(p, "{{.repository}}", r)
 	s = strings.ReplaceAll(s, "{{.number}}", fmt.Sprintf("%d", n))
+	s = strings.ReplaceAll(s, "{{.branch}}", url.PathEscape(app.cache.Branches[r][n]))
+
+	headOwner := app.cfg.PullRequestDependsOn.Owner
+	headRepo := r
+	headCloneURL := fmt.Sprintf("%s/%s/%s.git", app.githubAPI.WebURL, headOwner, headRepo)
+	details := app.cache.PullRequests[r][n]
+	if details != nil && details.HeadRepository != "" {
+		vals := strings.SplitN(details.HeadRepository, "/", 2)
+		headOwner = vals[0]
+		headRepo = vals[len(vals)-1]
+		headCloneURL = details.HeadCloneURL
+	}
+	s = strings.ReplaceAll(s, "{{.head_owner}}", headOwner)
+	s = strings.ReplaceAll(s, "{{.head_repository}}", headRepo)
+	s = strings.ReplaceAll(s, "{{.head_clone_url}}", url.QueryEscape(headCloneURL))
 	return s
 }
 
@@ -86,26 +136,75 @@ func (app *App) processJenkinsEndpointRetries(endpointDef *JenkinsEndpoint, repo
 	return errors.New("Unable to post to endpoint " + endpointDef.Path)
 }
 
-func (app *App) triggerPRJob(repo string, num int) {
+// triggerPRJob posts to Jenkins endpoints with no condition or a condition
+// from the list and records the result in the cache. It is called from
+// updateCache so the cache lock is already held.
+func (app *App) triggerPRJob(repo string, num int, conditions []string) {
+	if app.cfg.PullRequestDependsOn.Drafts.SkipJenkins && app.cache.IsDraft(repo, num) {
+		log.Print(fmt.Sprintf("Skipping Jenkins endpoints for draft pull request %s#%d", repo, num))
+		key := graphNodeKey(repo, num)
+		app.skippedTriggers[key] = append(app.skippedTriggers[key], conditions...)
+		return
+	}
+
 	log.Print(app.cfg)
+	result := &JenkinsTriggerResult{
+		Time:      time.Now(),
+		Endpoints: map[string]string{},
+	}
 	for _, endp := range app.cfg.Jenkins.Endpoints {
+		if !endp.CheckCondition(conditions) {
+			continue
+		}
 		rd, err := endp.GetRetryDelay()
 		if err != nil {
+			result.Endpoints[endp.Id] = err.Error()
 			break
 		}
 		rc, err := endp.GetRetryCount()
 		if err != nil {
+			result.Endpoints[endp.Id] = err.Error()
 			break
 		}
-		app.processJenkinsEndpointRetries(&endp, repo, num, rd, rc)
+		err = app.processJenkinsEndpointRetries(&endp, repo, num, rd, rc)
+		if err != nil {
+			result.Endpoints[endp.Id] = err.Error()
+		} else {
+			result.Endpoints[endp.Id] = "ok"
+		}
+	}
+
+	_, hasKey := app.cache.JenkinsTriggers[repo]
+	if !hasKey {
+		app.cache.JenkinsTriggers[repo] = map[int]*JenkinsTriggerResult{}
 	}
+	app.cache.JenkinsTriggers[repo][num] = result
+
+	app.events.Publish(EventJenkinsTriggered, repo, num, map[string]interface{}{
+		"endpoints": result.Endpoints,
+		"success":   result.Success(),
+	})
 }
 
-func (app *App) updateCache(action string, repo string, num int, branch string, depsAfter []string, branchesOnly bool) {
+func (app *App) updateCache(action string, repo string, num int, branch string, details *PullRequestDetails, depsAfter []string, branchesOnly bool) {
 	app.cache.mu.Lock()
 	defer app.wg.Done()
 	defer app.cache.mu.Unlock()
 
+	depsAfter = app.resolveDependsOn(depsAfter)
+
+	// pull requests that can be touched by this update
+	affected := append([]string{graphNodeKey(repo, num)}, depsAfter...)
+	affected = append(affected, dependencyList(app.cache.Dependencies[repo][num])...)
+	affected = append(affected, dependencyList(app.cache.Dependents[repo][num])...)
+	if action == "closed" {
+		// closed pull request leaves the merge queue
+		for _, n := range app.cache.MergeQueue[repo] {
+			affected = append(affected, graphNodeKey(repo, n))
+		}
+	}
+	defer app.cache.bumpRevision(app.cache.snapshot(affected))
+
 	// branches only
 	if action == "opened" || action == "edited" || action == "reopened" {
 		// set PR in Branches
@@ -113,15 +212,51 @@ func (app *App) updateCache(action string, repo string, num int, branch string,
 		if !hasKey {
 			app.cache.Branches[repo] = map[int]string{}
 		}
+		_, hasKey = app.cache.Branches[repo][num]
+		if !hasKey {
+			app.events.Publish(EventPullRequestAdded, repo, num, map[string]interface{}{"branch": branch})
+		}
 		app.cache.Branches[repo][num] = branch
 	}
 
+	// details are kept for open pull requests only, this also covers draft
+	// state changes from 'converted_to_draft' and 'ready_for_review' actions
+	_, isOpen := app.cache.Branches[repo][num]
+	if action != "closed" && isOpen && details != nil {
+		_, hasKey := app.cache.PullRequests[repo]
+		if !hasKey {
+			app.cache.PullRequests[repo] = map[int]*PullRequestDetails{}
+		}
+		// results for previous head are not needed anymore
+		previous := app.cache.PullRequests[repo][num]
+		if previous != nil && previous.HeadSHA != details.HeadSHA {
+			delete(app.cache.CI, previous.HeadSHA)
+			app.forgetPublishedStatuses(repo, previous.HeadSHA)
+		}
+		app.cache.PullRequests[repo][num] = details
+	}
+
 	if action == "closed" {
 		// unset PR from Branches
 		_, hasKey := app.cache.Branches[repo][num]
 		if hasKey {
 			delete(app.cache.Branches[repo], num)
+			app.events.Publish(EventPullRequestRemoved, repo, num, nil)
+		}
+		delete(app.cache.JenkinsTriggers[repo], num)
+		if app.cache.PullRequests[repo][num] != nil {
+			delete(app.cache.CI, app.cache.PullRequests[repo][num].HeadSHA)
+			app.forgetPublishedStatuses(repo, app.cache.PullRequests[repo][num].HeadSHA)
+		}
+		delete(app.cache.PullRequests[repo], num)
+		delete(app.cache.Reviews[repo], num)
+		queue := []int{}
+		for _, n := range app.cache.MergeQueue[repo] {
+			if n != num {
+				queue = append(queue, n)
+			}
 		}
+		app.cache.MergeQueue[repo] = queue
 	}
 
 	if branchesOnly {
@@ -178,6 +313,11 @@ func (app *App) updateCache(action string, repo string, num int, branch string,
 			if err == nil {
 				_, hasKey := app.cache.Branches[vals[0]][i]
 				if !hasKey {
+					// dependency merged while listed stays so that the pull
+					// request is not blocked and is ready
+					if depsBefore[vals[0]] == i {
+						app.cache.Dependencies[repo][num][vals[0]] = i
+					}
 					// tidy up - remove entries for non-existing PR
 					_, hasKey2 := app.cache.Dependencies[vals[0]][i]
 					if hasKey2 {
@@ -191,7 +331,7 @@ func (app *App) updateCache(action string, repo string, num int, branch string,
 					// set PR in Dependencies and Dependents
 					if action == "opened" || action == "edited" || action == "reopened" {
 						app.cache.Dependencies[repo][num][vals[0]] = i
-	
+
 						// set dependency PR in Dependents
 						_, hasKey2 := app.cache.Dependents[vals[0]]
 						if !hasKey2 {
@@ -247,24 +387,170 @@ func (app *App) updateCache(action string, repo string, num int, branch string,
 		}
 	}
 
-	if action == "edited" {
-		if !reflect.DeepEqual(depsBefore, app.cache.Dependencies[repo][num]) {
-			app.triggerPRJob(repo, num)
+	depsChanged := !reflect.DeepEqual(dependencyList(depsBefore), dependencyList(app.cache.Dependencies[repo][num]))
+	if depsChanged {
+		app.events.Publish(EventDependenciesChange, repo, num, map[string]interface{}{
+			"before": dependencyList(depsBefore),
+			"after":  dependencyList(app.cache.Dependencies[repo][num]),
+		})
+	}
+
+	conditions := []string{}
+	added, removed := diffDependencyLists(dependencyList(depsBefore), dependencyList(app.cache.Dependencies[repo][num]))
+	for _, dep := range added {
+		app.events.Publish(EventDependencyAdded, repo, num, map[string]interface{}{"dependency": dep})
+	}
+	if len(added) > 0 {
+		conditions = append(conditions, JenkinsConditionDependencyAdded)
+	}
+	for _, dep := range removed {
+		app.events.Publish(EventDependencyRemoved, repo, num, map[string]interface{}{"dependency": dep})
+	}
+	if len(removed) > 0 {
+		conditions = append(conditions, JenkinsConditionDependencyRemoved)
+	}
+
+	if action == "edited" && depsChanged {
+		app.triggerPRJob(repo, num, conditions)
+	}
+
+	// catch up on triggers that were skipped while it was a draft
+	skipped, wasSkipped := app.skippedTriggers[graphNodeKey(repo, num)]
+	if action == "ready_for_review" && wasSkipped {
+		delete(app.skippedTriggers, graphNodeKey(repo, num))
+		app.triggerPRJob(repo, num, append(skipped, JenkinsConditionReadyForReview))
+	}
+	if action == "closed" {
+		delete(app.skippedTriggers, graphNodeKey(repo, num))
+	}
+
+	app.afterCacheUpdate(append(affected, dependencyList(app.cache.Dependents[repo][num])...))
+}
+
+// hasCachedDependencies returns true when the pull request is cached with
+// dependencies that match the DependsOn lines.
+func (app *App) hasCachedDependencies(repo string, num int, dependsOn []string) bool {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	_, isOpen := app.cache.Branches[repo][num]
+	if !isOpen {
+		return false
+	}
+	return reflect.DeepEqual(app.getExpectedDependencies(repo, num, dependsOn), dependencyList(app.cache.Dependencies[repo][num]))
+}
+
+// updateDetails stores pull request details without touching dependencies.
+func (app *App) updateDetails(repo string, num int, branch string, details *PullRequestDetails) {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	_, isOpen := app.cache.Branches[repo][num]
+	if !isOpen {
+		return
+	}
+
+	affected := append([]string{graphNodeKey(repo, num)}, dependencyList(app.cache.Dependents[repo][num])...)
+	defer app.cache.bumpRevision(app.cache.snapshot(affected))
+
+	app.cache.Branches[repo][num] = branch
+	_, hasKey := app.cache.PullRequests[repo]
+	if !hasKey {
+		app.cache.PullRequests[repo] = map[int]*PullRequestDetails{}
+	}
+	previous := app.cache.PullRequests[repo][num]
+	if previous != nil && previous.HeadSHA != details.HeadSHA {
+		delete(app.cache.CI, previous.HeadSHA)
+		app.forgetPublishedStatuses(repo, previous.HeadSHA)
+	}
+	app.cache.PullRequests[repo][num] = details
+
+	app.afterCacheUpdate(affected)
+}
+
+// diffDependencyLists returns entries added to and removed from a sorted list.
+func diffDependencyLists(before []string, after []string) ([]string, []string) {
+	added := []string{}
+	removed := []string{}
+	for _, d := range after {
+		i := sort.SearchStrings(before, d)
+		if i == len(before) || before[i] != d {
+			added = append(added, d)
 		}
 	}
+	for _, d := range before {
+		i := sort.SearchStrings(after, d)
+		if i == len(after) || after[i] != d {
+			removed = append(removed, d)
+		}
+	}
+	return added, removed
 }
 
-func (app *App) startHandler(cli *gocli.CLI) int {
-	c, err := ioutil.ReadFile(cli.Flag("config"))
+// dependencyList returns dependencies as a sorted list of repo#number strings.
+func dependencyList(deps map[string]int) []string {
+	l := []string{}
+	for r, n := range deps {
+		l = append(l, graphNodeKey(r, n))
+	}
+	sort.Strings(l)
+	return l
+}
+
+// loadConfig reads the config file and sets up GitHub clients.
+func (app *App) loadConfig(path string) {
+	c, err := ioutil.ReadFile(path)
 	if err != nil {
 		log.Fatal("Error reading config file")
 	}
 
 	var cfg Config
 	cfg.SetFromJSON(c)
+	cfg.Labels.SetDefaults()
+	cfg.MergeTrain.SetDefaults()
+	cfg.GitHub.SetDefaults()
+	cfg.Webhooks.SetDefaults()
 	app.cfg = cfg
+	app.cache.DraftsBlockDependents = app.cfg.PullRequestDependsOn.Drafts.BlockDependents
+	app.events = NewEventBus(app.cfg.EventsBacklogSize, app.cache.Boot)
+	app.githubAPI.MaxPages = app.cfg.GitHub.MaxPages
+	err = app.githubAPI.Configure(&app.cfg.GitHub)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if app.cfg.GitHub.App != nil {
+		if app.cfg.Token != "" {
+			log.Print("GitHub App is configured so outgoing_github_token is not used")
+		}
+		app.githubAppAuth, err = NewGitHubAppAuth(app.cfg.GitHub.App.AppID, app.cfg.GitHub.App.PrivateKeyPath, app.githubAPI)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if app.cfg.GitHub.App.InstallationID != 0 {
+			app.githubAppAuth.SetInstallation(app.cfg.PullRequestDependsOn.Owner, app.cfg.GitHub.App.InstallationID)
+		}
+	}
+}
 
-	repos, err := app.githubAPI.GetRepositoriesList(app.cfg.PullRequestDependsOn.Owner, app.cfg.PullRequestDependsOn.Organization, app.cfg.Token)
+func (app *App) startHandler(cli *gocli.CLI) int {
+	app.startedAt = time.Now()
+	app.loadConfig(cli.Flag("config"))
+
+	// pull requests fetched along with repositories, when GraphQL is used
+	var bootstrapPulls map[string][]PullRequest
+	var repos []string
+	err := retryWithBackoff(app.cfg.GitHub.BootstrapRetries, 2*time.Second, func() error {
+		var err error
+		if app.cfg.GitHub.GraphQLBootstrap {
+			repos, bootstrapPulls, err = app.githubAPI.GetRepositoriesWithPullRequests(app.cfg.PullRequestDependsOn.Owner, app.checkIfRepoShouldBeIncluded, app.getGitHubToken())
+		} else {
+			repos, err = app.githubAPI.GetRepositoriesList(app.cfg.PullRequestDependsOn.Owner, app.cfg.PullRequestDependsOn.Organization, app.getGitHubToken())
+		}
+		if err != nil {
+			log.Print("Error fetching repository list from GitHub: " + err.Error())
+		}
+		return err
+	})
 	if err != nil {
 		log.Fatal("Error fetching repository list from GitHub")
 	}
@@ -280,35 +566,14 @@ func (app *App) startHandler(cli *gocli.CLI) int {
 	log.Print("The following repositories match rules in the config file:")
 	log.Print(filteredRepos)
 
-	// Nasty loop in a loop but this is executed just twice when app is initialized
-	for _, repo := range filteredRepos {
-		pullRequests, err := app.githubAPI.GetPullRequestList(app.cfg.PullRequestDependsOn.Owner, repo, app.cfg.Token)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Error fetching pull requests for %s", app.cfg.PullRequestDependsOn.Owner))
-		}
-		log.Print(fmt.Sprintf("The following pull requests have been found in the %s/%s repository", app.cfg.PullRequestDependsOn.Owner, repo))
-		log.Print(pullRequests)
-
-		for _, pr := range pullRequests {
-			app.wg.Add(1)
-			go app.updateCache("opened", pr.Repository, pr.Number, pr.Branch, pr.DependsOn, true)
-			app.wg.Wait()
-		}
+	app.setRepositories(filteredRepos)
+	if app.cfg.Webhooks.Sync {
+		app.syncHooks(filteredRepos, true)
 	}
+	app.bootstrap(filteredRepos, bootstrapPulls)
 
-	// again same loop - sorry, dependencies have to be added once all PRs are available
-	for _, repo := range filteredRepos {
-		pullRequests, err := app.githubAPI.GetPullRequestList(app.cfg.PullRequestDependsOn.Owner, repo, app.cfg.Token)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Error fetching pull requests for %s", app.cfg.PullRequestDependsOn.Owner))
-		}
-
-		for _, pr := range pullRequests {
-			app.wg.Add(1)
-			go app.updateCache("opened", pr.Repository, pr.Number, pr.Branch, pr.DependsOn, false)
-			app.wg.Wait()
-		}
-	}
+	// labels and statuses could have drifted while the daemon was not running
+	app.reconcileAll()
 
 	log.Print("The following Branches have been cached:")
 	log.Print(app.cache.Branches)
@@ -316,6 +581,9 @@ func (app *App) startHandler(cli *gocli.CLI) int {
 	log.Print("The following Dependencies have been found:")
 	log.Print(app.cache.Dependencies)
 
+	app.startReconciler()
+	app.startDeliveryRecovery()
+
 	done := make(chan bool)
 	go app.startAPI()
 	<-done
@@ -325,6 +593,13 @@ func (app *App) startHandler(cli *gocli.CLI) int {
 func (app *App) startAPI() {
 	router := mux.NewRouter()
 	router.HandleFunc("/", app.apiHandler).Methods("POST", "GET")
+	router.HandleFunc("/graph", app.apiHandlerGraph).Methods("GET")
+	router.HandleFunc("/events", app.apiHandlerEvents).Methods("GET")
+	router.HandleFunc("/pulls/{repository}/{number:[0-9]+}", app.apiHandlerPullRequest).Methods("GET")
+	router.HandleFunc("/metrics", app.apiHandlerMetrics).Methods("GET")
+	if app.cfg.UI.Enabled {
+		router.HandleFunc("/ui", app.uiHandler).Methods("GET")
+	}
 	log.Print("Starting daemon listening on " + app.cfg.Port + "...")
 	log.Fatal(http.ListenAndServe(":"+app.cfg.Port, router))
 }
@@ -339,20 +614,67 @@ func (app *App) apiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (app *App) apiHandlerGet(w http.ResponseWriter, r *http.Request) {
+func (app *App) checkAPIToken(w http.ResponseWriter, r *http.Request) bool {
 	if app.cfg.APITokenHeader != "" && app.cfg.APITokenValue != "" {
 		if r.Header.Get(app.cfg.APITokenHeader) != app.cfg.APITokenValue {
 			w.WriteHeader(http.StatusUnauthorized)
-			return
+			return false
 		}
 	}
+	return true
+}
+
+func (app *App) apiHandlerGet(w http.ResponseWriter, r *http.Request) {
+	if !app.checkAPIToken(w, r) {
+		return
+	}
 
-	b, err := json.Marshal(app.cache)
+	app.cache.mu.Lock()
+	etag := app.cache.ETag()
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		app.cache.mu.Unlock()
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	filter, err := NewCacheFilterFromQuery(r.URL.Query())
+	if err != nil {
+		app.cache.mu.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var b []byte
+	since := r.URL.Query().Get("since")
+	if since != "" {
+		i, errParse := strconv.ParseUint(since, 10, 64)
+		if errParse != nil {
+			app.cache.mu.Unlock()
+			http.Error(w, "Invalid since revision", http.StatusBadRequest)
+			return
+		}
+		// revision restarts with the daemon and old removals are pruned
+		if i > app.cache.Revision || i < app.cache.Pruned {
+			app.cache.mu.Unlock()
+			http.Error(w, "Since revision is not available, fetch the whole cache", http.StatusGone)
+			return
+		}
+		b, err = json.Marshal(app.cache.Diff(i, filter))
+	} else if len(r.URL.Query()) > 0 {
+		b, err = json.Marshal(app.cache.Subset(func(repo string, num int) bool {
+			return filter.Matches(&app.cache, repo, num)
+		}))
+	} else {
+		b, err = json.Marshal(&app.cache)
+	}
+	app.cache.mu.Unlock()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("content-type", "application/json")
+	w.Header().Set("ETag", etag)
 	w.Write(b)
 }
 
@@ -380,6 +702,7 @@ func (app *App) apiHandlerPost(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	app.markDelivery(app.githubPayload.GetDeliveryID(r))
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("content-type", "application/json")
@@ -392,12 +715,56 @@ func (app *App) processGitHubPayload(b *([]byte), event string) error {
 		return errors.New("Got non-JSON payload")
 	}
 
+	app.processPayloadInstallation(j)
+
 	if app.cfg.PullRequestDependsOn != nil && event == "pull_request" {
 		err = app.processPayloadOnPullRequestDependsOn(j, event)
 		if err != nil {
 			log.Print("Error processing github payload on PullRequestDependsOn. Breaking.")
 		}
 	}
+
+	if app.cfg.PullRequestDependsOn != nil && app.cfg.MergeTrain.Enabled && event == "pull_request" {
+		err = app.processPayloadOnMergeTrainLabel(j, event)
+		if err != nil {
+			log.Print("Error processing github payload on MergeTrain. Breaking.")
+		}
+	}
+
+	if app.cfg.PullRequestDependsOn != nil && app.cfg.MergeTrain.Enabled && event == "issue_comment" {
+		err = app.processPayloadOnIssueComment(j, event)
+		if err != nil {
+			log.Print("Error processing github payload on MergeTrain. Breaking.")
+		}
+	}
+
+	if app.cfg.PullRequestDependsOn != nil && event == "merge_group" {
+		err = app.processPayloadOnMergeGroup(j, event)
+		if err != nil {
+			log.Print("Error processing github payload on MergeGroup. Breaking.")
+		}
+	}
+
+	if app.cfg.PullRequestDependsOn != nil && event == "repository" {
+		err = app.processPayloadOnRepository(j, event)
+		if err != nil {
+			log.Print("Error processing github payload on Repository. Breaking.")
+		}
+	}
+
+	if app.cfg.PullRequestDependsOn != nil && event == "pull_request_review" {
+		err = app.processPayloadOnPullRequestReview(j, event)
+		if err != nil {
+			log.Print("Error processing github payload on PullRequestReview. Breaking.")
+		}
+	}
+
+	if app.cfg.PullRequestDependsOn != nil && (event == "status" || event == "check_run" || event == "check_suite") {
+		err = app.processPayloadOnCI(j, event)
+		if err != nil {
+			log.Print("Error processing github payload on CI. Breaking.")
+		}
+	}
 	return nil
 }
 
@@ -450,9 +817,7 @@ func (app *App) processPayloadOnPullRequestDependsOn(j map[string]interface{}, e
 	if repo == "" {
 		return nil
 	}
-	if body == "" {
-		return nil
-	}
+	bodyFrom, bodyChanged := app.githubPayload.GetBodyChangedFrom(j)
 
 	f := app.checkIfRepoShouldBeIncluded(repo)
 	if !f {
@@ -460,20 +825,39 @@ func (app *App) processPayloadOnPullRequestDependsOn(j map[string]interface{}, e
 		return nil
 	}
 
+	// pull requests without a body have no dependencies but details, draft
+	// state, new head and closing still have to be stored
 	dependsOn := []string{}
-	lines := strings.Split(body, "\r\n")
-	for _, line := range lines {
-		m, _ := regexp.MatchString("^DependsOn:[a-z0-9\\-_]{3,40}#[0-9]{1,10}$", line)
-		if m {
-			dependsOnLine := strings.Split(line, ":")
-			dependsOn = append(dependsOn, dependsOnLine[1])
+	if body != "" {
+		dependsOn = app.githubAPI.getDependsOnLinesFromBody(body)
+		log.Print("Got payload with the following DependsOn:")
+		log.Print(dependsOn)
+	}
+
+	details := app.githubPayload.GetPullRequestDetails(j)
+	if action == "edited" {
+		baseFrom := app.githubPayload.GetBaseBranchChangedFrom(j)
+		if baseFrom != "" {
+			log.Print(fmt.Sprintf("Base branch of %s#%d changed from %s to %s", repo, number, baseFrom, details.BaseBranch))
+		}
+
+		// title or base only edits, or body edits that keep DependsOn lines,
+		// do not need the dependencies to be rebuilt
+		dependsOnFrom := app.githubAPI.getDependsOnLinesFromBody(bodyFrom)
+		sort.Strings(dependsOnFrom)
+		sortedDependsOn := append([]string{}, dependsOn...)
+		sort.Strings(sortedDependsOn)
+		// pull request could have been missed or its dependencies opened
+		// in the meantime
+		if (!bodyChanged || reflect.DeepEqual(dependsOnFrom, sortedDependsOn)) && app.hasCachedDependencies(repo, number, dependsOn) {
+			log.Print(fmt.Sprintf("DependsOn of %s#%d did not change", repo, number))
+			app.updateDetails(repo, number, branch, details)
+			return nil
 		}
 	}
-	log.Print("Got payload with the following DependsOn:")
-	log.Print(dependsOn)
 
 	app.wg.Add(1)
-	go app.updateCache(action, repo, number, branch, dependsOn, false)
+	go app.updateCache(action, repo, number, branch, details, dependsOn, false)
 	app.wg.Wait()
 
 	return nil
@@ -483,11 +867,25 @@ func (app *App) Run() {
 	app.githubPayload = NewGitHubPayload()
 	app.githubAPI = NewGitHubAPI()
 	app.jenkinsAPI = NewJenkinsAPI()
+	app.publishedStatuses = map[string]string{}
+	app.skippedTriggers = map[string][]string{}
+	app.quietKeys = map[string]int{}
+	app.repositoryAliases = map[string]string{}
+	app.mergeTrains = NewMergeTrains()
+	app.reconcileCorrections = map[string]uint64{}
+	app.deliveries = map[string]time.Time{}
 	app.cache = Cache{
-		Branches:     map[string]map[int]string{},
-		Dependencies: map[string]map[int]map[string]int{},
-		Dependents:   map[string]map[int]map[string]int{},
-		Version:      "1",
+		Branches:        map[string]map[int]string{},
+		Dependencies:    map[string]map[int]map[string]int{},
+		Dependents:      map[string]map[int]map[string]int{},
+		JenkinsTriggers: map[string]map[int]*JenkinsTriggerResult{},
+		Changes:         map[string]uint64{},
+		PullRequests:    map[string]map[int]*PullRequestDetails{},
+		CI:              map[string]map[string]*CIResult{},
+		Reviews:         map[string]map[int]map[string]string{},
+		MergeQueue:      map[string][]int{},
+		Version:         "1",
+		Boot:            strconv.FormatInt(time.Now().UnixNano(), 36),
 	}
 
 	os.Exit(app.cli.Run(os.Stdout, os.Stderr))
@@ -504,6 +902,17 @@ func NewApp() *App {
 	app.cli = gocli.NewCLI("github-pullrequestd", "Tiny API to store GitHub Pull Request dependencies", "Nicholas Gasior <mg@gen64.io>")
 	cmdStart := app.cli.AddCmd("start", "Starts API", app.startHandler)
 	cmdStart.AddFlag("config", "c", "config", "Config file", gocli.TypePathFile|gocli.MustExist|gocli.Required, nil)
+	cmdGraph := app.cli.AddCmd("graph", "Prints dependency graph fetched from running daemon", app.graphHandler)
+	cmdGraph.AddFlag("config", "c", "config", "Config file", gocli.TypePathFile|gocli.MustExist|gocli.Required, nil)
+	cmdGraph.AddFlag("url", "u", "url", "Daemon URL, defaults to http://localhost:PORT", gocli.TypeString, nil)
+	cmdGraph.AddFlag("format", "f", "dot|mermaid", "Output format, defaults to dot", gocli.TypeAlphanumeric, nil)
+	cmdGraph.AddFlag("scope", "s", "all|component|neighborhood", "Part of the graph to print, defaults to all", gocli.TypeAlphanumeric, nil)
+	cmdGraph.AddFlag("repository", "r", "repository", "Repository of the pull request when scope is not all", gocli.TypeAlphanumeric|gocli.AllowHyphen|gocli.AllowUnderscore|gocli.AllowDots, nil)
+	cmdGraph.AddFlag("number", "n", "number", "Number of the pull request when scope is not all", gocli.TypeInt, nil)
+	cmdHooks := app.cli.AddCmd("hooks", "Checks or fixes webhooks pointing to the daemon", app.hooksHandler)
+	cmdHooks.AddFlag("config", "c", "config", "Config file", gocli.TypePathFile|gocli.MustExist|gocli.Required, nil)
+	cmdHooks.AddArg("action", "sync|check", "Action, sync creates or updates webhooks and check only reports", gocli.TypeAlphanumeric|gocli.Required)
+	cmdHooks.AddPostValidation(app.validateHooksAction)
 	_ = app.cli.AddCmd("version", "Prints version", app.versionHandler)
 
 	return app
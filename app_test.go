@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestApp returns an app with empty cache tracking the given repositories,
+// without any GitHub or Jenkins side effects enabled.
+func newTestApp(t *testing.T, repos ...string) *App {
+	t.Helper()
+	app := &App{}
+	app.githubAPI = NewGitHubAPI()
+	app.jenkinsAPI = NewJenkinsAPI()
+	app.publishedStatuses = map[string]string{}
+	app.skippedTriggers = map[string][]string{}
+	app.quietKeys = map[string]int{}
+	app.repositoryAliases = map[string]string{}
+	app.mergeTrains = NewMergeTrains()
+	app.reconcileCorrections = map[string]uint64{}
+	app.deliveries = map[string]time.Time{}
+	app.cache = Cache{
+		Branches:        map[string]map[int]string{},
+		Dependencies:    map[string]map[int]map[string]int{},
+		Dependents:      map[string]map[int]map[string]int{},
+		JenkinsTriggers: map[string]map[int]*JenkinsTriggerResult{},
+		Changes:         map[string]uint64{},
+		PullRequests:    map[string]map[int]*PullRequestDetails{},
+		CI:              map[string]map[string]*CIResult{},
+		Reviews:         map[string]map[int]map[string]string{},
+		MergeQueue:      map[string][]int{},
+		Version:         "1",
+		Boot:            "test",
+	}
+	include := []DependsOnConditionRepository{{Name: "*"}}
+	exclude := []DependsOnConditionRepository{}
+	app.cfg.PullRequestDependsOn = &PullRequestDependsOn{
+		Owner:               "owner1",
+		Repositories:        &include,
+		ExcludeRepositories: &exclude,
+	}
+	app.events = NewEventBus(100, "test")
+	app.setRepositories(repos)
+	return app
+}
+
+// openPullRequests adds pull requests to the cache the way bootstrap does.
+func openPullRequests(app *App, pullRequests ...PullRequest) {
+	app.addPullRequests(pullRequests)
+}
+
+func testPullRequest(repo string, num int, dependsOn ...string) PullRequest {
+	return PullRequest{
+		Repository: repo,
+		Number:     num,
+		Branch:     "branch-" + repo,
+		DependsOn:  dependsOn,
+		Details: &PullRequestDetails{
+			HeadSHA:   repo + "-sha",
+			UpdatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func editedPayload(repo string, num int, body string) map[string]interface{} {
+	return map[string]interface{}{
+		"action": "edited",
+		"number": float64(num),
+		"changes": map[string]interface{}{
+			"title": map[string]interface{}{"from": "Old title"},
+		},
+		"pull_request": map[string]interface{}{
+			"body":  body,
+			"title": "New title",
+			"head":  map[string]interface{}{"ref": "branch-" + repo, "sha": repo + "-sha"},
+			"base": map[string]interface{}{
+				"ref":  "main",
+				"repo": map[string]interface{}{"name": repo},
+			},
+		},
+	}
+}
+
+func TestTitleEditAddsMissedPullRequest(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	app.githubPayload = NewGitHubPayload()
+	openPullRequests(app, testPullRequest("repo-b", 2))
+
+	err := app.processPayloadOnPullRequestDependsOn(editedPayload("repo-a", 1, "DependsOn:repo-b#2"), "pull_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.cache.Branches["repo-a"][1] != "branch-repo-a" {
+		t.Fatalf("expected repo-a#1 to be cached")
+	}
+	if app.cache.Dependencies["repo-a"][1]["repo-b"] != 2 {
+		t.Fatalf("expected repo-a#1 to depend on repo-b#2, got %v", app.cache.Dependencies["repo-a"][1])
+	}
+}
+
+func TestTitleEditAddsDependencyOpenedLater(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	app.githubPayload = NewGitHubPayload()
+	// dependency is not known yet so it is dropped
+	openPullRequests(app, testPullRequest("repo-a", 1, "repo-b#2"))
+	app.cache.mu.Lock()
+	app.cache.Branches["repo-b"] = map[int]string{2: "branch-repo-b"}
+	app.cache.mu.Unlock()
+
+	err := app.processPayloadOnPullRequestDependsOn(editedPayload("repo-a", 1, "DependsOn:repo-b#2"), "pull_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.cache.Dependencies["repo-a"][1]["repo-b"] != 2 {
+		t.Fatalf("expected repo-a#1 to depend on repo-b#2, got %v", app.cache.Dependencies["repo-a"][1])
+	}
+	if app.cache.PullRequests["repo-a"][1].Title != "New title" {
+		t.Fatalf("expected details to be updated")
+	}
+}
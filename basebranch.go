@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func (app *App) getBaseBranchPolicy() string {
+	if app.cfg.PullRequestDependsOn.BaseBranchPolicy == "" {
+		return PolicyAllow
+	}
+	return app.cfg.PullRequestDependsOn.BaseBranchPolicy
+}
+
+// getBaseBranchMismatches returns dependencies that target a different base
+// branch than the pull request. Caller must hold the cache lock.
+func (app *App) getBaseBranchMismatches(repo string, num int) []string {
+	mismatches := []string{}
+	details := app.cache.PullRequests[repo][num]
+	if details == nil || details.BaseBranch == "" {
+		return mismatches
+	}
+	for r, n := range app.cache.Dependencies[repo][num] {
+		depDetails := app.cache.PullRequests[r][n]
+		if depDetails == nil || depDetails.BaseBranch == "" {
+			continue
+		}
+		if depDetails.BaseBranch != details.BaseBranch {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%s)", graphNodeKey(r, n), depDetails.BaseBranch))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// checkBaseBranchPolicy publishes a status according to the configured policy.
+// Caller must hold the cache lock.
+func (app *App) checkBaseBranchPolicy(repo string, num int) {
+	policy := app.getBaseBranchPolicy()
+	if policy == PolicyAllow {
+		return
+	}
+
+	mismatches := app.getBaseBranchMismatches(repo, num)
+	if len(mismatches) == 0 {
+		app.setCommitStatus(repo, num, "base-branch", StatusSuccess, "Dependencies target the same base branch")
+		return
+	}
+
+	description := "Dependencies target different base branch: " + strings.Join(mismatches, ", ")
+	if policy == PolicyBlock {
+		app.setCommitStatus(repo, num, "base-branch", StatusFailure, description)
+	} else {
+		app.setCommitStatus(repo, num, "base-branch", StatusSuccess, "Warning: "+description)
+	}
+}
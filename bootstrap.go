@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryWithBackoff calls fn until it succeeds or attempts run out, doubling
+// the delay between attempts.
+func retryWithBackoff(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay << uint(i-1))
+		}
+		err = fn()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (app *App) fetchPullRequestList(repo string) ([]PullRequest, error) {
+	var pullRequests []PullRequest
+	err := retryWithBackoff(app.cfg.GitHub.BootstrapRetries, 2*time.Second, func() error {
+		var err error
+		pullRequests, err = app.githubAPI.GetPullRequestList(app.cfg.PullRequestDependsOn.Owner, repo, app.getGitHubToken())
+		if err != nil {
+			log.Print(fmt.Sprintf("Error fetching pull requests for %s/%s: %s", app.cfg.PullRequestDependsOn.Owner, repo, err.Error()))
+		}
+		return err
+	})
+	return pullRequests, err
+}
+
+// fetchPullRequestLists fetches open pull requests of repositories that were
+// not fetched already, using a bounded number of workers.
+func (app *App) fetchPullRequestLists(repos []string, fetched map[string][]PullRequest) (map[string][]PullRequest, map[string]string) {
+	pulls := map[string][]PullRequest{}
+	failed := map[string]string{}
+	var mu sync.Mutex
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < app.cfg.GitHub.BootstrapWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for repo := range queue {
+				pullRequests, err := app.fetchPullRequestList(repo)
+				mu.Lock()
+				if err != nil {
+					failed[repo] = err.Error()
+				} else {
+					pulls[repo] = pullRequests
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, repo := range repos {
+		pullRequests, hasKey := fetched[repo]
+		if hasKey {
+			pulls[repo] = pullRequests
+			continue
+		}
+		queue <- repo
+	}
+	close(queue)
+	wg.Wait()
+	return pulls, failed
+}
+
+func getPullRequestKeys(pullRequests []PullRequest) []string {
+	keys := []string{}
+	for _, pr := range pullRequests {
+		keys = append(keys, graphNodeKey(pr.Repository, pr.Number))
+	}
+	return keys
+}
+
+// applyPullRequests adds pull requests to the cache as if they were opened.
+// Dependencies can be added only once all the pull requests are in the
+// branches so it has to be called with branchesOnly first. Labels and
+// statuses of the pull requests are not published while the graph is half
+// built, reconcileKeys has to be called afterwards.
+func (app *App) applyPullRequests(pullRequests []PullRequest, branchesOnly bool) {
+	keys := getPullRequestKeys(pullRequests)
+	app.cache.mu.Lock()
+	for _, k := range keys {
+		app.quietKeys[k]++
+	}
+	app.cache.mu.Unlock()
+	defer func() {
+		app.cache.mu.Lock()
+		for _, k := range keys {
+			app.quietKeys[k]--
+			if app.quietKeys[k] == 0 {
+				delete(app.quietKeys, k)
+			}
+		}
+		app.cache.mu.Unlock()
+	}()
+
+	for _, pr := range pullRequests {
+		app.wg.Add(1)
+		go app.updateCache("opened", pr.Repository, pr.Number, pr.Branch, pr.Details, pr.DependsOn, branchesOnly)
+		app.wg.Wait()
+
+		// merge train checks approvals and CI even when they are not
+		// aggregated
+		if branchesOnly && (app.cfg.Statuses.AggregateReviews || app.cfg.MergeTrain.Enabled) {
+			app.fetchReviews(pr.Repository, pr.Number)
+		}
+		if branchesOnly && (app.cfg.Statuses.AggregateCI || app.cfg.MergeTrain.Enabled) && pr.Details != nil {
+			app.fetchCIResults(pr.Repository, pr.Details.HeadSHA)
+		}
+	}
+}
+
+// addPullRequests adds pull requests to the cache and publishes their labels
+// and statuses once all of them are added.
+func (app *App) addPullRequests(pullRequests []PullRequest) {
+	app.applyPullRequests(pullRequests, true)
+	app.applyPullRequests(pullRequests, false)
+	app.reconcileKeys(getPullRequestKeys(pullRequests))
+}
+
+// bootstrap fills the cache with open pull requests of the repositories.
+// Repositories that fail are reported and retried in the background so the
+// daemon can start with the rest.
+func (app *App) bootstrap(repos []string, fetched map[string][]PullRequest) {
+	pulls, failed := app.fetchPullRequestLists(repos, fetched)
+	app.resolveRenamedRepositories(pulls)
+
+	all := []PullRequest{}
+	for _, repo := range repos {
+		if pulls[repo] == nil {
+			continue
+		}
+		log.Print(fmt.Sprintf("The following pull requests have been found in the %s/%s repository", app.cfg.PullRequestDependsOn.Owner, repo))
+		log.Print(pulls[repo])
+		all = append(all, pulls[repo]...)
+	}
+	// labels and statuses could have drifted while the daemon was not
+	// running, they are published by reconcileAll once the whole graph is
+	// built
+	app.applyPullRequests(all, true)
+	app.applyPullRequests(all, false)
+
+	if len(failed) > 0 {
+		app.addBootstrapFailed(failed)
+		log.Print(fmt.Sprintf("Starting without repositories that failed to bootstrap: %s", strings.Join(app.getBootstrapFailed(), ", ")))
+	}
+}
+
+// addBootstrapFailed records repositories that failed to bootstrap and makes
+// sure they are retried in the background.
+func (app *App) addBootstrapFailed(failed map[string]string) {
+	app.bootstrapMu.Lock()
+	defer app.bootstrapMu.Unlock()
+
+	if app.bootstrapFailed == nil {
+		app.bootstrapFailed = map[string]string{}
+	}
+	for repo, err := range failed {
+		app.bootstrapFailed[repo] = err
+	}
+	if len(app.bootstrapFailed) > 0 && !app.bootstrapRetrying {
+		app.bootstrapRetrying = true
+		go app.retryBootstrapFailed()
+	}
+}
+
+// removeBootstrapFailed stops retrying the repository.
+func (app *App) removeBootstrapFailed(repo string) {
+	app.bootstrapMu.Lock()
+	defer app.bootstrapMu.Unlock()
+	delete(app.bootstrapFailed, repo)
+}
+
+// getBootstrapFailed returns sorted names of repositories that are still not
+// bootstrapped.
+func (app *App) getBootstrapFailed() []string {
+	app.bootstrapMu.Lock()
+	defer app.bootstrapMu.Unlock()
+
+	repos := []string{}
+	for repo := range app.bootstrapFailed {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// retryBootstrapFailed keeps fetching repositories that failed to bootstrap
+// until all of them succeed.
+func (app *App) retryBootstrapFailed() {
+	delay := time.Minute
+	for {
+		time.Sleep(delay)
+
+		for _, repo := range app.getBootstrapFailed() {
+			// repository could have been deleted or renamed in the meantime
+			if !app.isRepositoryTracked(repo) {
+				app.removeBootstrapFailed(repo)
+				continue
+			}
+
+			pullRequests, err := app.fetchPullRequestList(repo)
+			if err != nil {
+				app.bootstrapMu.Lock()
+				app.bootstrapFailed[repo] = err.Error()
+				app.bootstrapMu.Unlock()
+				continue
+			}
+
+			log.Print(fmt.Sprintf("Repository %s/%s bootstrapped after retry", app.cfg.PullRequestDependsOn.Owner, repo))
+			app.addPullRequests(pullRequests)
+			app.refreshDependentsOf(repo)
+
+			app.removeBootstrapFailed(repo)
+		}
+
+		app.bootstrapMu.Lock()
+		if len(app.bootstrapFailed) == 0 {
+			app.bootstrapRetrying = false
+			app.bootstrapMu.Unlock()
+			return
+		}
+		app.bootstrapMu.Unlock()
+		if delay < 15*time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+// refreshDependentsOf adds dependencies on a repository that was not
+// bootstrapped yet, they were dropped as the pull requests were missing.
+func (app *App) refreshDependentsOf(repo string) {
+	app.cache.mu.Lock()
+	repos := []string{}
+	for r := range app.cache.Branches {
+		if r != repo {
+			repos = append(repos, r)
+		}
+	}
+	app.cache.mu.Unlock()
+
+	for _, r := range repos {
+		pullRequests, err := app.fetchPullRequestList(r)
+		if err != nil {
+			continue
+		}
+		dependents := []PullRequest{}
+		for _, pr := range pullRequests {
+			app.cache.mu.Lock()
+			dependsOn := app.resolveDependsOn(pr.DependsOn)
+			app.cache.mu.Unlock()
+			for _, dep := range dependsOn {
+				if strings.HasPrefix(dep, repo+"#") {
+					dependents = append(dependents, pr)
+					break
+				}
+			}
+		}
+		app.applyPullRequests(dependents, false)
+		app.reconcileKeys(getPullRequestKeys(dependents))
+	}
+}
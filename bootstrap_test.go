@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBootstrapPublishesStatusesOnceGraphIsBuilt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	app := newTestApp(t, "repo-a", "repo-b")
+	app.githubAPI.BaseURL = srv.URL
+	app.cfg.Statuses.Enabled = true
+
+	// dependent comes before its dependency so a status published in the
+	// first pass would say there is nothing to wait for
+	pullRequests := []PullRequest{
+		testPullRequest("repo-a", 1, "repo-b#2"),
+		testPullRequest("repo-b", 2),
+	}
+	app.applyPullRequests(pullRequests, true)
+	app.applyPullRequests(pullRequests, false)
+	if len(app.publishedStatuses) != 0 {
+		t.Fatalf("expected no statuses while bootstrapping, got %v", app.publishedStatuses)
+	}
+
+	app.reconcileAll()
+	key := "repo-a@repo-a-sha|pullrequestd/dependencies"
+	if app.publishedStatuses[key] != StatusPending+"|Waiting for open pull requests: repo-b#2" {
+		t.Fatalf("unexpected dependencies status %q", app.publishedStatuses[key])
+	}
+}
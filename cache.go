@@ -1,13 +1,278 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Cache struct {
-	Branches     map[string]map[int]string         `json:"branches"`
-	Dependencies map[string]map[int]map[string]int `json:"dependencies"`
-	Dependents   map[string]map[int]map[string]int `json:"dependents"`
-	Version      string
-	mu           sync.Mutex
+	Branches        map[string]map[int]string                `json:"branches"`
+	Dependencies    map[string]map[int]map[string]int        `json:"dependencies"`
+	Dependents      map[string]map[int]map[string]int        `json:"dependents"`
+	JenkinsTriggers map[string]map[int]*JenkinsTriggerResult `json:"jenkins_triggers"`
+	PullRequests    map[string]map[int]*PullRequestDetails   `json:"pull_requests"`
+	CI              map[string]map[string]*CIResult          `json:"ci"`
+	Reviews         map[string]map[int]map[string]string     `json:"reviews"`
+	MergeQueue      map[string][]int                         `json:"merge_queue"`
+	Version         string
+	Revision        uint64            `json:"revision"`
+	Boot            string            `json:"boot"`
+	Changes         map[string]uint64 `json:"-"`
+	// DraftsBlockDependents makes draft dependencies fail the dependents
+	// instead of keeping them pending
+	DraftsBlockDependents bool `json:"-"`
+	// Pruned is the newest revision of dropped removals, diffs since older
+	// revisions would miss them
+	Pruned uint64 `json:"-"`
+	mu     sync.Mutex
+}
+
+// maxRemovedChanges is number of closed pull requests remembered for diffs.
+const maxRemovedChanges = 1000
+
+type CacheDiff struct {
+	Revision        uint64                                   `json:"revision"`
+	Boot            string                                   `json:"boot"`
+	Since           uint64                                   `json:"since"`
+	Branches        map[string]map[int]string                `json:"branches"`
+	Dependencies    map[string]map[int]map[string]int        `json:"dependencies"`
+	Dependents      map[string]map[int]map[string]int        `json:"dependents"`
+	JenkinsTriggers map[string]map[int]*JenkinsTriggerResult `json:"jenkins_triggers"`
+	PullRequests    map[string]map[int]*PullRequestDetails   `json:"pull_requests"`
+	Removed         []string                                 `json:"removed"`
+}
+
+// fingerprint returns a string describing everything cached about the pull
+// request so that changes can be detected.
+func (c *Cache) fingerprint(repo string, num int) string {
+	branch, hasBranch := c.Branches[repo][num]
+	trigger := ""
+	if c.JenkinsTriggers[repo][num] != nil {
+		trigger = c.JenkinsTriggers[repo][num].Time.String()
+	}
+	details := ""
+	if c.PullRequests[repo][num] != nil {
+		details = fmt.Sprintf("%v", *c.PullRequests[repo][num])
+	}
+	queued := -1
+	for i, n := range c.MergeQueue[repo] {
+		if n == num {
+			queued = i
+		}
+	}
+	// single results are served with the cache, aggregates would miss changes
+	// that keep the combined state
+	ci := []string{}
+	if c.PullRequests[repo][num] != nil {
+		for context, r := range c.CI[c.PullRequests[repo][num].HeadSHA] {
+			ci = append(ci, context+"="+r.State)
+		}
+	}
+	sort.Strings(ci)
+	reviews := []string{}
+	for user, state := range c.Reviews[repo][num] {
+		reviews = append(reviews, user+"="+state)
+	}
+	sort.Strings(reviews)
+	return fmt.Sprintf("%t|%s|%s|%s|%s|%s|%s|%s|%d", hasBranch, branch,
+		strings.Join(dependencyList(c.Dependencies[repo][num]), ","),
+		strings.Join(dependencyList(c.Dependents[repo][num]), ","),
+		trigger, details, strings.Join(ci, ","), strings.Join(reviews, ","), queued)
+}
+
+// snapshot returns fingerprints of the given pull requests and of their
+// transitive dependents, as aggregated state and labels of the latter
+// follow the former.
+func (c *Cache) snapshot(keys []string) map[string]string {
+	fps := map[string]string{}
+	for _, k := range keys {
+		r, n, err := splitGraphNodeKey(k)
+		if err != nil {
+			continue
+		}
+		fps[k] = c.fingerprint(r, n)
+		for _, d := range c.TransitiveDependents(r, n) {
+			if _, hasKey := fps[d]; !hasKey {
+				dr, dn, _ := splitGraphNodeKey(d)
+				fps[d] = c.fingerprint(dr, dn)
+			}
+		}
+	}
+	return fps
+}
+
+// ETag returns entity tag of the current revision. It contains the boot id
+// so that revisions from before a restart do not match.
+func (c *Cache) ETag() string {
+	return fmt.Sprintf("\"%s-%d\"", c.Boot, c.Revision)
+}
+
+// bumpRevision compares pull requests against the snapshot and increases
+// the revision if any of them changed.
+func (c *Cache) bumpRevision(before map[string]string) {
+	changed := []string{}
+	for k, fp := range before {
+		r, n, _ := splitGraphNodeKey(k)
+		if c.fingerprint(r, n) != fp {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	c.Revision++
+	for _, k := range changed {
+		c.Changes[k] = c.Revision
+	}
+	c.pruneChanges()
+}
+
+// pruneChanges forgets the oldest closed pull requests when there are more
+// than maxRemovedChanges of them.
+func (c *Cache) pruneChanges() {
+	removed := []string{}
+	for k := range c.Changes {
+		r, n, _ := splitGraphNodeKey(k)
+		if _, hasKey := c.Branches[r][n]; !hasKey {
+			removed = append(removed, k)
+		}
+	}
+	if len(removed) <= maxRemovedChanges {
+		return
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return c.Changes[removed[i]] < c.Changes[removed[j]]
+	})
+	for _, k := range removed[:len(removed)-maxRemovedChanges] {
+		if c.Changes[k] > c.Pruned {
+			c.Pruned = c.Changes[k]
+		}
+		delete(c.Changes, k)
+	}
+}
+
+// Diff returns pull requests changed after the given revision that match
+// the filter. Caller must hold the cache lock.
+func (c *Cache) Diff(since uint64, f *CacheFilter) *CacheDiff {
+	subset := c.Subset(func(r string, n int) bool {
+		return c.Changes[graphNodeKey(r, n)] > since && f.Matches(c, r, n)
+	})
+	d := &CacheDiff{
+		Revision:        c.Revision,
+		Boot:            c.Boot,
+		Since:           since,
+		Branches:        subset.Branches,
+		Dependencies:    subset.Dependencies,
+		Dependents:      subset.Dependents,
+		JenkinsTriggers: subset.JenkinsTriggers,
+		PullRequests:    subset.PullRequests,
+		Removed:         []string{},
+	}
+	for k, rev := range c.Changes {
+		if rev <= since {
+			continue
+		}
+		r, n, _ := splitGraphNodeKey(k)
+		_, hasKey := c.Branches[r][n]
+		if !hasKey && f.MatchesRepository(r) {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Removed)
+	return d
+}
+
+// Subset returns a new cache with cached pull requests for which fn returns
+// true. Caller must hold the cache lock.
+func (c *Cache) Subset(fn func(repo string, num int) bool) *Cache {
+	s := &Cache{
+		Branches:        map[string]map[int]string{},
+		Dependencies:    map[string]map[int]map[string]int{},
+		Dependents:      map[string]map[int]map[string]int{},
+		JenkinsTriggers: map[string]map[int]*JenkinsTriggerResult{},
+		PullRequests:    map[string]map[int]*PullRequestDetails{},
+		Version:         c.Version,
+		Revision:        c.Revision,
+		Boot:            c.Boot,
+	}
+	for r, prs := range c.Branches {
+		for n, branch := range prs {
+			if !fn(r, n) {
+				continue
+			}
+			if s.Branches[r] == nil {
+				s.Branches[r] = map[int]string{}
+			}
+			s.Branches[r][n] = branch
+			if c.Dependencies[r][n] != nil {
+				if s.Dependencies[r] == nil {
+					s.Dependencies[r] = map[int]map[string]int{}
+				}
+				s.Dependencies[r][n] = c.Dependencies[r][n]
+			}
+			if c.Dependents[r][n] != nil {
+				if s.Dependents[r] == nil {
+					s.Dependents[r] = map[int]map[string]int{}
+				}
+				s.Dependents[r][n] = c.Dependents[r][n]
+			}
+			if c.JenkinsTriggers[r][n] != nil {
+				if s.JenkinsTriggers[r] == nil {
+					s.JenkinsTriggers[r] = map[int]*JenkinsTriggerResult{}
+				}
+				s.JenkinsTriggers[r][n] = c.JenkinsTriggers[r][n]
+			}
+			if c.PullRequests[r][n] != nil {
+				if s.PullRequests[r] == nil {
+					s.PullRequests[r] = map[int]*PullRequestDetails{}
+				}
+				s.PullRequests[r][n] = c.PullRequests[r][n]
+			}
+		}
+	}
+	return s
+}
+
+type PullRequestDetails struct {
+	Title          string    `json:"title"`
+	Author         string    `json:"author"`
+	URL            string    `json:"url"`
+	BaseBranch     string    `json:"base_branch"`
+	HeadSHA        string    `json:"head_sha"`
+	HeadRepository string    `json:"head_repository"`
+	HeadCloneURL   string    `json:"head_clone_url"`
+	Fork           bool      `json:"fork"`
+	Draft          bool      `json:"draft"`
+	Labels         []string  `json:"labels"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	MergeableState string    `json:"mergeable_state,omitempty"`
+}
+
+// HasLabel returns true when the pull request has the label.
+func (d *PullRequestDetails) HasLabel(label string) bool {
+	for _, l := range d.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+type JenkinsTriggerResult struct {
+	Time      time.Time         `json:"time"`
+	Endpoints map[string]string `json:"endpoints"`
+}
+
+// Success returns true when all endpoints were posted to successfully.
+func (r *JenkinsTriggerResult) Success() bool {
+	for _, v := range r.Endpoints {
+		if v != "ok" {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRevisionFollowsSingleCIResultsAndReviews(t *testing.T) {
+	app := newTestApp(t, "repo-a")
+	openPullRequests(app, testPullRequest("repo-a", 1))
+
+	app.updateCIResult("repo-a-sha", "jenkins", StatusPending)
+	before := app.cache.Revision
+	// combined state stays pending
+	app.updateCIResult("repo-a-sha", "lint", StatusPending)
+	if app.cache.Revision == before {
+		t.Fatalf("expected revision to change on a new CI context")
+	}
+
+	app.cache.mu.Lock()
+	app.setReviews("repo-a", 1, map[string]string{"user1": ReviewChangesRequested})
+	before = app.cache.Revision
+	snapshot := app.cache.snapshot([]string{"repo-a#1"})
+	// still not approved
+	app.setReviews("repo-a", 1, map[string]string{"user1": ReviewChangesRequested, "user2": ReviewCommented})
+	app.cache.bumpRevision(snapshot)
+	app.cache.mu.Unlock()
+	if app.cache.Revision == before {
+		t.Fatalf("expected revision to change on a new review")
+	}
+}
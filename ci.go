@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// CIResult is the latest result of a single CI context on a commit.
+type CIResult struct {
+	Context string `json:"context"`
+	State   string `json:"state"`
+}
+
+// normalizeCheckState maps check run and check suite status and conclusion to
+// commit status state.
+func normalizeCheckState(status string, conclusion string) string {
+	if status != "completed" {
+		return StatusPending
+	}
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return StatusSuccess
+	}
+	return StatusFailure
+}
+
+// combineCIStates returns failure if any state failed, pending if any is
+// still running and success when all passed. Empty string means no results.
+func combineCIStates(states []string) string {
+	combined := ""
+	for _, s := range states {
+		switch s {
+		case StatusFailure, StatusError:
+			return StatusFailure
+		case StatusPending:
+			combined = StatusPending
+		case StatusSuccess:
+			if combined == "" {
+				combined = StatusSuccess
+			}
+		}
+	}
+	return combined
+}
+
+// GetCIState returns combined state of all CI contexts on the pull request
+// head. Caller must hold the cache lock.
+func (c *Cache) GetCIState(repo string, num int) string {
+	details := c.PullRequests[repo][num]
+	if details == nil || details.HeadSHA == "" {
+		return ""
+	}
+	states := []string{}
+	for _, r := range c.CI[details.HeadSHA] {
+		states = append(states, r.State)
+	}
+	return combineCIStates(states)
+}
+
+// TransitiveDependencies returns open pull requests the pull request depends
+// on directly or indirectly. Caller must hold the cache lock.
+func (c *Cache) TransitiveDependencies(repo string, num int) []string {
+	return c.walk(c.Dependencies, repo, num)
+}
+
+// TransitiveDependents returns pull requests that depend on the pull request
+// directly or indirectly. Caller must hold the cache lock.
+func (c *Cache) TransitiveDependents(repo string, num int) []string {
+	return c.walk(c.Dependents, repo, num)
+}
+
+func (c *Cache) walk(edges map[string]map[int]map[string]int, repo string, num int) []string {
+	start := graphNodeKey(repo, num)
+	seen := map[string]bool{start: true}
+	queue := []string{start}
+	found := []string{}
+	for len(queue) > 0 {
+		r, n, _ := splitGraphNodeKey(queue[0])
+		queue = queue[1:]
+		for _, k := range dependencyList(edges[r][n]) {
+			dr, dn, _ := splitGraphNodeKey(k)
+			_, isOpen := c.Branches[dr][dn]
+			if seen[k] || !isOpen {
+				continue
+			}
+			seen[k] = true
+			found = append(found, k)
+			queue = append(queue, k)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+type CombinedCI struct {
+	State   string   `json:"state"`
+	Failing []string `json:"failing"`
+	Pending []string `json:"pending"`
+}
+
+// GetCombinedCI returns CI state of the pull request and all its transitive
+// dependencies. Caller must hold the cache lock.
+func (app *App) GetCombinedCI(repo string, num int) *CombinedCI {
+	combined := &CombinedCI{
+		Failing: []string{},
+		Pending: []string{},
+	}
+	states := []string{}
+	keys := append([]string{graphNodeKey(repo, num)}, app.cache.TransitiveDependencies(repo, num)...)
+	for _, k := range keys {
+		r, n, _ := splitGraphNodeKey(k)
+		s := app.cache.GetCIState(r, n)
+		switch s {
+		case StatusFailure:
+			combined.Failing = append(combined.Failing, k)
+		case StatusPending, "":
+			// pull request without any results yet is not green either
+			combined.Pending = append(combined.Pending, k)
+			s = StatusPending
+		}
+		states = append(states, s)
+	}
+	combined.State = combineCIStates(states)
+	return combined
+}
+
+// checkCombinedCI publishes the combined CI status. Caller must hold the
+// cache lock.
+func (app *App) checkCombinedCI(repo string, num int) {
+	if !app.cfg.Statuses.AggregateCI {
+		return
+	}
+	combined := app.GetCombinedCI(repo, num)
+	switch combined.State {
+	case StatusFailure:
+		app.setCommitStatus(repo, num, "ci", StatusFailure, "Failing: "+strings.Join(combined.Failing, ", "))
+	case StatusPending:
+		app.setCommitStatus(repo, num, "ci", StatusPending, "Waiting for: "+strings.Join(combined.Pending, ", "))
+	default:
+		app.setCommitStatus(repo, num, "ci", StatusSuccess, "CI passed on the pull request and its dependencies")
+	}
+}
+
+// updateCIResult stores CI result for a commit and re-evaluates pull requests
+// with that head and everything that depends on them. Results for commits
+// that are not a head of any cached pull request are dropped as nothing
+// would clean them up.
+func (app *App) updateCIResult(sha string, context string, state string) {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	affected := []string{}
+	for r, prs := range app.cache.PullRequests {
+		for n, details := range prs {
+			if details.HeadSHA == sha {
+				affected = append(affected, graphNodeKey(r, n))
+			}
+		}
+	}
+	if len(affected) == 0 {
+		return
+	}
+	defer app.cache.bumpRevision(app.cache.snapshot(affected))
+
+	_, hasKey := app.cache.CI[sha]
+	if !hasKey {
+		app.cache.CI[sha] = map[string]*CIResult{}
+	}
+	app.cache.CI[sha][context] = &CIResult{
+		Context: context,
+		State:   state,
+	}
+
+	app.afterCacheUpdate(affected)
+}
+
+// fetchCIResults gets current CI results of the commit from GitHub, used when
+// bootstrapping as results received before the start are not known.
+func (app *App) fetchCIResults(repo string, sha string) {
+	if sha == "" {
+		return
+	}
+	results, err := app.githubAPI.GetCommitCIResults(app.cfg.PullRequestDependsOn.Owner, repo, sha, app.getGitHubToken())
+	if err != nil {
+		log.Print(fmt.Sprintf("Error fetching CI results for %s@%s: %s", repo, sha, err.Error()))
+		return
+	}
+
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+	ci := map[string]*CIResult{}
+	for i := range results {
+		// statuses published by the daemon itself must not count
+		if results[i].Context == "" || strings.HasPrefix(results[i].Context, app.cfg.Statuses.GetContext("")) {
+			continue
+		}
+		ci[results[i].Context] = &results[i]
+	}
+	app.cache.CI[sha] = ci
+}
+
+func (app *App) processPayloadOnCI(j map[string]interface{}, event string) error {
+	repo := getJSONString(j, "repository", "name")
+	if repo == "" || !app.checkIfRepoShouldBeIncluded(repo) {
+		return nil
+	}
+
+	sha := ""
+	context := ""
+	state := ""
+	switch event {
+	case "status":
+		sha = getJSONString(j, "sha")
+		context = getJSONString(j, "context")
+		state = getJSONString(j, "state")
+	case "check_run":
+		sha = getJSONString(j, "check_run", "head_sha")
+		context = "check_run/" + getJSONString(j, "check_run", "name")
+		state = normalizeCheckState(getJSONString(j, "check_run", "status"), getJSONString(j, "check_run", "conclusion"))
+	case "check_suite":
+		sha = getJSONString(j, "check_suite", "head_sha")
+		context = "check_suite/" + getJSONString(j, "check_suite", "app", "slug")
+		state = normalizeCheckState(getJSONString(j, "check_suite", "status"), getJSONString(j, "check_suite", "conclusion"))
+	}
+	if sha == "" || state == "" {
+		return nil
+	}
+
+	// statuses published by the daemon itself must not count
+	if strings.HasPrefix(context, app.cfg.Statuses.GetContext("")) {
+		return nil
+	}
+
+	log.Print(fmt.Sprintf("Got CI result %s for %s on %s/%s", state, context, repo, sha))
+	app.updateCIResult(sha, context, state)
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBootstrapFetchesCIResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner1/repo-a/commits/repo-a-sha/status":
+			w.Write([]byte(`{"statuses":[{"context":"jenkins","state":"success"},{"context":"pullrequestd/ci","state":"pending"}]}`))
+		case "/repos/owner1/repo-a/commits/repo-a-sha/check-runs":
+			w.Write([]byte(`{"check_runs":[{"name":"lint","status":"completed","conclusion":"success"}]}`))
+		case "/repos/owner1/repo-a/commits/repo-a-sha/check-suites":
+			w.Write([]byte(`{"check_suites":[{"app":{"slug":"actions"},"status":"in_progress"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	app := newTestApp(t, "repo-a")
+	app.githubAPI.BaseURL = srv.URL
+	app.cfg.Statuses.AggregateCI = true
+	openPullRequests(app, testPullRequest("repo-a", 1))
+
+	ci := app.cache.CI["repo-a-sha"]
+	if len(ci) != 3 {
+		t.Fatalf("expected 3 CI results without own statuses, got %v", ci)
+	}
+	if ci["jenkins"].State != StatusSuccess || ci["check_run/lint"].State != StatusSuccess || ci["check_suite/actions"].State != StatusPending {
+		t.Fatalf("unexpected CI results %v", ci)
+	}
+	if app.cache.GetCIState("repo-a", 1) != StatusPending {
+		t.Fatalf("expected pending, got %s", app.cache.GetCIState("repo-a", 1))
+	}
+}
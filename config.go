@@ -16,6 +16,75 @@ type Config struct {
 	APITokenHeader       string                `json:"incoming_api_token_header";omitempty`
 	PullRequestDependsOn *PullRequestDependsOn `json:"pull_request_depends_on";omitempty`
 	Jenkins              Jenkins               `json:"jenkins"`
+	UI                   UI                    `json:"ui"`
+	EventsBacklogSize    int                   `json:"events_backlog_size,omitempty"`
+	Statuses             Statuses              `json:"statuses"`
+	Labels               Labels                `json:"labels"`
+	MergeTrain           MergeTrain            `json:"merge_train"`
+	GitHub               GitHub                `json:"github"`
+	Webhooks             Webhooks              `json:"webhooks"`
+}
+
+type Webhooks struct {
+	// URL is where GitHub sends webhooks to, it identifies hooks of the daemon
+	URL          string `json:"url,omitempty"`
+	Organization bool   `json:"organization"`
+	// Sync creates or updates webhooks on startup
+	Sync      bool `json:"sync"`
+	Recover   bool `json:"recover"`
+	Redeliver bool `json:"redeliver"`
+	// RecoverWindow is number of seconds before the start to look for
+	// missed deliveries
+	RecoverWindow   int `json:"recover_window,omitempty"`
+	RecoverInterval int `json:"recover_interval,omitempty"`
+}
+
+// SetDefaults fills webhook settings that are not set in the config.
+func (h *Webhooks) SetDefaults() {
+	if h.RecoverWindow == 0 {
+		h.RecoverWindow = 3600
+	}
+	if h.RecoverInterval == 0 {
+		h.RecoverInterval = 300
+	}
+}
+
+type GitHub struct {
+	MaxPages int `json:"max_pages,omitempty"`
+	// App authenticates as a GitHub App instead of using
+	// outgoing_github_token, it is set with app_id, private_key_path and
+	// optionally installation_id
+	App        *GitHubApp `json:"app,omitempty"`
+	APIURL     string     `json:"api_url,omitempty"`
+	GraphQLURL string     `json:"graphql_url,omitempty"`
+	WebURL     string     `json:"web_url,omitempty"`
+	CABundle   string     `json:"ca_bundle,omitempty"`
+	Proxy      string     `json:"proxy,omitempty"`
+	MaxRetries int        `json:"max_retries,omitempty"`
+	// GraphQLBootstrap fetches repositories and pull requests on startup with
+	// GraphQL instead of one REST request per repository
+	GraphQLBootstrap bool `json:"graphql_bootstrap"`
+	BootstrapWorkers int  `json:"bootstrap_workers,omitempty"`
+	BootstrapRetries int  `json:"bootstrap_retries,omitempty"`
+	// ReconcileInterval is number of seconds between re-listing pull
+	// requests to repair the cache, 0 disables it
+	ReconcileInterval int `json:"reconcile_interval,omitempty"`
+}
+
+// SetDefaults fills GitHub settings that are not set in the config.
+func (g *GitHub) SetDefaults() {
+	if g.BootstrapWorkers <= 0 {
+		g.BootstrapWorkers = 4
+	}
+	if g.BootstrapRetries <= 0 {
+		g.BootstrapRetries = 3
+	}
+}
+
+type GitHubApp struct {
+	AppID          string `json:"app_id"`
+	PrivateKeyPath string `json:"private_key_path"`
+	InstallationID int64  `json:"installation_id,omitempty"`
 }
 
 func (c *Config) SetFromJSON(b []byte) {
@@ -30,8 +99,98 @@ type PullRequestDependsOn struct {
 	Organization        bool                              `json:"organization";omit_empty`
 	Repositories        *([]DependsOnConditionRepository) `json:"repositories";omitempty`
 	ExcludeRepositories *([]DependsOnConditionRepository) `json:"exclude_repositories";omitempty`
+	BaseBranchPolicy    string                            `json:"base_branch_policy,omitempty"`
+	Drafts              Drafts                            `json:"drafts"`
+}
+
+type Drafts struct {
+	SkipJenkins     bool `json:"skip_jenkins"`
+	BlockDependents bool `json:"block_dependents"`
+}
+
+const (
+	PolicyAllow = "allow"
+	PolicyWarn  = "warn"
+	PolicyBlock = "block"
+)
+
+type Labels struct {
+	Enabled           bool   `json:"enabled"`
+	HasDependencies   string `json:"has_dependencies,omitempty"`
+	Blocked           string `json:"blocked,omitempty"`
+	DependencyOf      string `json:"dependency_of,omitempty"`
+	DependenciesReady string `json:"dependencies_ready,omitempty"`
+}
+
+// SetDefaults fills label names that are not set in the config.
+func (l *Labels) SetDefaults() {
+	if l.HasDependencies == "" {
+		l.HasDependencies = "has-dependencies"
+	}
+	if l.Blocked == "" {
+		l.Blocked = "blocked-by-dependency"
+	}
+	if l.DependencyOf == "" {
+		l.DependencyOf = "dependency-of-other-pr"
+	}
+	if l.DependenciesReady == "" {
+		l.DependenciesReady = "dependencies-ready"
+	}
+}
+
+type MergeTrain struct {
+	Enabled     bool   `json:"enabled"`
+	Label       string `json:"label,omitempty"`
+	Command     string `json:"command,omitempty"`
+	MergeMethod string `json:"merge_method,omitempty"`
+	Timeout     int    `json:"timeout,omitempty"`
 }
 
+// SetDefaults fills merge train settings that are not set in the config.
+func (m *MergeTrain) SetDefaults() {
+	if m.Label == "" {
+		m.Label = "merge-train"
+	}
+	if m.Command == "" {
+		m.Command = "/merge-train"
+	}
+	if m.MergeMethod == "" {
+		m.MergeMethod = "merge"
+	}
+	if m.Timeout == 0 {
+		m.Timeout = 300
+	}
+}
+
+type Statuses struct {
+	Enabled          bool   `json:"enabled"`
+	ContextPrefix    string `json:"context_prefix,omitempty"`
+	AggregateCI      bool   `json:"aggregate_ci"`
+	AggregateReviews bool   `json:"aggregate_reviews"`
+}
+
+// GetContext returns status context prefixed with the configured value.
+func (s *Statuses) GetContext(name string) string {
+	prefix := s.ContextPrefix
+	if prefix == "" {
+		prefix = "pullrequestd"
+	}
+	return prefix + "/" + name
+}
+
+type UI struct {
+	Enabled  bool   `json:"enabled"`
+	Auth     string `json:"auth,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+const (
+	UIAuthAPIToken = "api_token"
+	UIAuthBasic    = "basic"
+	UIAuthNone     = "none"
+)
+
 type DependsOnConditionRepository struct {
 	Name   string `json:"name"`
 	RegExp bool   `json:"regexp";omit_empty`
@@ -53,6 +212,26 @@ type JenkinsEndpoint struct {
 	Condition string                 `json:"condition"`
 }
 
+const (
+	JenkinsConditionDependencyAdded   = "dependency_added"
+	JenkinsConditionDependencyRemoved = "dependency_removed"
+	JenkinsConditionReadyForReview    = "ready_for_review"
+)
+
+// CheckCondition returns true when endpoint has no condition or its condition
+// is one of the given ones.
+func (endpoint *JenkinsEndpoint) CheckCondition(conditions []string) bool {
+	if endpoint.Condition == "" {
+		return true
+	}
+	for _, c := range conditions {
+		if c == endpoint.Condition {
+			return true
+		}
+	}
+	return false
+}
+
 func (endpoint *JenkinsEndpoint) GetRetryCount() (int, error) {
 	rc := int(1)
 	if endpoint.Retry.Count != "" {
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+type DashboardPullRequest struct {
+	Repository string
+	Number     int
+	Branch     string
+	State      string
+	URL        string
+	Title      string
+	DependsOn  []string
+	Trigger    *JenkinsTriggerResult
+}
+
+type DashboardGroup struct {
+	PullRequests []DashboardPullRequest
+}
+
+type Dashboard struct {
+	Groups     []DashboardGroup
+	Standalone int
+	Generated  time.Time
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard.html").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).ParseFS(templatesFS, "templates/dashboard.html"))
+
+func (app *App) getPullRequestURL(repo string, num int) string {
+	return fmt.Sprintf("%s/%s/%s/pull/%d", app.githubAPI.WebURL, app.cfg.PullRequestDependsOn.Owner, repo, num)
+}
+
+func (app *App) getDashboard() *Dashboard {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	d := &Dashboard{
+		Groups:    []DashboardGroup{},
+		Generated: time.Now(),
+	}
+	g := NewGraphFromCache(&app.cache)
+	for _, c := range g.Components() {
+		if len(c.Edges) == 0 {
+			d.Standalone += len(c.Nodes)
+			continue
+		}
+		group := DashboardGroup{}
+		for _, n := range c.SortedNodes() {
+			pr := DashboardPullRequest{
+				Repository: n.Repository,
+				Number:     n.Number,
+				Branch:     n.Branch,
+				State:      n.State,
+				URL:        app.getPullRequestURL(n.Repository, n.Number),
+				DependsOn:  []string{},
+				Trigger:    app.cache.JenkinsTriggers[n.Repository][n.Number],
+			}
+			details := app.cache.PullRequests[n.Repository][n.Number]
+			if details != nil {
+				pr.Title = details.Title
+				if details.URL != "" {
+					pr.URL = details.URL
+				}
+			}
+			for _, e := range c.sortedEdges() {
+				if e.From == graphNodeKey(n.Repository, n.Number) {
+					pr.DependsOn = append(pr.DependsOn, e.To)
+				}
+			}
+			group.PullRequests = append(group.PullRequests, pr)
+		}
+		d.Groups = append(d.Groups, group)
+	}
+	return d
+}
+
+func (app *App) checkUIAuth(w http.ResponseWriter, r *http.Request) bool {
+	switch app.cfg.UI.Auth {
+	case UIAuthNone:
+		return true
+	case UIAuthBasic:
+		u, p, ok := r.BasicAuth()
+		if !ok || u != app.cfg.UI.User || p != app.cfg.UI.Password {
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"github-pullrequestd\"")
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	return app.checkAPIToken(w, r)
+}
+
+func (app *App) uiHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.checkUIAuth(w, r) {
+		return
+	}
+
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, app.getDashboard())
+	if err != nil {
+		log.Print("Error rendering dashboard: " + err.Error())
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// refetchedEvents are events whose state is fetched from GitHub when
+// bootstrapping, replaying older deliveries of them would revert it.
+var refetchedEvents = map[string]bool{
+	"pull_request":        true,
+	"pull_request_review": true,
+	"status":              true,
+	"check_run":           true,
+	"check_suite":         true,
+}
+
+type hookRef struct {
+	Repository string
+	Hook       Hook
+}
+
+// markDelivery remembers that the delivery has been processed.
+func (app *App) markDelivery(guid string) {
+	if guid == "" {
+		return
+	}
+	app.deliveriesMu.Lock()
+	defer app.deliveriesMu.Unlock()
+
+	// older deliveries are not looked at when recovering
+	window := time.Duration(app.cfg.Webhooks.RecoverWindow) * time.Second
+	for g, t := range app.deliveries {
+		if time.Since(t) > 2*window {
+			delete(app.deliveries, g)
+		}
+	}
+	app.deliveries[guid] = time.Now()
+}
+
+func (app *App) isDeliveryProcessed(guid string) bool {
+	app.deliveriesMu.Lock()
+	defer app.deliveriesMu.Unlock()
+	_, hasKey := app.deliveries[guid]
+	return hasKey
+}
+
+// getOwnHooks returns webhooks that point to the daemon, on the organization
+// or on each tracked repository.
+func (app *App) getOwnHooks() []hookRef {
+	repos := []string{""}
+	if !app.cfg.Webhooks.Organization {
+		repos = app.getRepositories()
+	}
+
+	refs := []hookRef{}
+	for _, repo := range repos {
+		hooks, err := app.githubAPI.GetHooks(app.cfg.PullRequestDependsOn.Owner, repo, app.getGitHubToken())
+		if err != nil {
+			log.Print(fmt.Sprintf("Error getting webhooks of %s/%s: %s", app.cfg.PullRequestDependsOn.Owner, repo, err.Error()))
+			continue
+		}
+		for _, hook := range hooks {
+			if hook.URL == app.cfg.Webhooks.URL {
+				refs = append(refs, hookRef{Repository: repo, Hook: hook})
+			}
+		}
+	}
+	return refs
+}
+
+// recoverDeliveries finds deliveries made after since that were not
+// processed and processes them again, or asks GitHub to redeliver them.
+func (app *App) recoverDeliveries(since time.Time) {
+	recovered := 0
+	for _, ref := range app.getOwnHooks() {
+		deliveries, err := app.githubAPI.GetHookDeliveries(app.cfg.PullRequestDependsOn.Owner, ref.Repository, ref.Hook.ID, since, app.getGitHubToken())
+		if err != nil {
+			log.Print(fmt.Sprintf("Error getting deliveries of hook %d: %s", ref.Hook.ID, err.Error()))
+			continue
+		}
+
+		// redeliveries share the guid, one successful attempt is enough
+		latest := map[string]HookDelivery{}
+		succeeded := map[string]bool{}
+		for _, d := range deliveries {
+			if d.Success() {
+				succeeded[d.GUID] = true
+			}
+			if latest[d.GUID].ID == 0 || d.DeliveredAt.After(latest[d.GUID].DeliveredAt) {
+				latest[d.GUID] = d
+			}
+		}
+		missed := []HookDelivery{}
+		for guid, d := range latest {
+			if d.Event == "ping" || app.isDeliveryProcessed(guid) {
+				continue
+			}
+			// successful ones from before the start were processed by the
+			// previous run
+			if succeeded[guid] && d.DeliveredAt.Before(app.startedAt) {
+				continue
+			}
+			if refetchedEvents[d.Event] && d.DeliveredAt.Before(app.startedAt) {
+				continue
+			}
+			missed = append(missed, d)
+		}
+		sort.Slice(missed, func(i, j int) bool {
+			return missed[i].DeliveredAt.Before(missed[j].DeliveredAt)
+		})
+
+		for _, d := range missed {
+			log.Print(fmt.Sprintf("Recovering %s delivery %s from %s", d.Event, d.GUID, d.DeliveredAt.String()))
+			if app.cfg.Webhooks.Redeliver {
+				err = app.githubAPI.RedeliverHookDelivery(app.cfg.PullRequestDependsOn.Owner, ref.Repository, ref.Hook.ID, d.ID, app.getGitHubToken())
+				if err != nil {
+					log.Print(err.Error())
+					continue
+				}
+				recovered++
+				continue
+			}
+
+			event, payload, err := app.githubAPI.GetHookDeliveryPayload(app.cfg.PullRequestDependsOn.Owner, ref.Repository, ref.Hook.ID, d.ID, app.getGitHubToken())
+			if err != nil {
+				log.Print(err.Error())
+				continue
+			}
+			err = app.processGitHubPayload(&payload, event)
+			if err != nil {
+				log.Print(fmt.Sprintf("Error processing delivery %s: %s", d.GUID, err.Error()))
+				continue
+			}
+			app.markDelivery(d.GUID)
+			recovered++
+		}
+	}
+	if recovered > 0 {
+		log.Print(fmt.Sprintf("Recovered %d missed webhook deliveries", recovered))
+	}
+}
+
+// startDeliveryRecovery recovers deliveries missed while the daemon was down
+// and then keeps checking for failed ones.
+func (app *App) startDeliveryRecovery() {
+	if !app.cfg.Webhooks.Recover || app.cfg.Webhooks.URL == "" {
+		return
+	}
+	go func() {
+		since := app.startedAt.Add(-time.Duration(app.cfg.Webhooks.RecoverWindow) * time.Second)
+		interval := time.Duration(app.cfg.Webhooks.RecoverInterval) * time.Second
+		for {
+			started := time.Now()
+			app.recoverDeliveries(since)
+			// overlap as failed deliveries show up with a delay
+			since = started.Add(-interval)
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverDeliveriesSkipsRefetchedEvents(t *testing.T) {
+	startedAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) string {
+		return startedAt.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)
+	}
+	redelivered := []string{}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/owner1/repo-a/hooks":
+			w.Write([]byte(`[{"id":1,"active":true,"config":{"url":"https://example.com/hook"}}]`))
+		case r.URL.Path == "/repos/owner1/repo-a/hooks/1/deliveries" && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/owner1/repo-a/hooks/1/deliveries?page=2>; rel="next"`, srv.URL))
+			w.Write([]byte(fmt.Sprintf(`[
+				{"id":1,"guid":"a","event":"status","status_code":502,"delivered_at":"%s"},
+				{"id":2,"guid":"b","event":"issue_comment","status_code":502,"delivered_at":"%s"}
+			]`, at(5), at(-5))))
+		case r.URL.Path == "/repos/owner1/repo-a/hooks/1/deliveries":
+			w.Write([]byte(fmt.Sprintf(`[
+				{"id":3,"guid":"c","event":"pull_request_review","status_code":502,"delivered_at":"%s"},
+				{"id":4,"guid":"d","event":"issue_comment","status_code":502,"delivered_at":"%s"}
+			]`, at(-10), at(-120))))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/attempts"):
+			redelivered = append(redelivered, strings.Split(r.URL.Path, "/")[7])
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	app := newTestApp(t, "repo-a")
+	app.githubAPI.BaseURL = srv.URL
+	app.cfg.Webhooks.URL = "https://example.com/hook"
+	app.cfg.Webhooks.Redeliver = true
+	app.startedAt = startedAt
+
+	app.recoverDeliveries(startedAt.Add(-time.Hour))
+	sort.Strings(redelivered)
+	if strings.Join(redelivered, ",") != "1,2" {
+		t.Fatalf("expected deliveries 1 and 2 to be redelivered, got %v", redelivered)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventPullRequestAdded   = "pull_request_added"
+	EventPullRequestRemoved = "pull_request_removed"
+	EventDependenciesChange = "dependencies_changed"
+	EventDependencyAdded    = "dependency_added"
+	EventDependencyRemoved  = "dependency_removed"
+	EventJenkinsTriggered   = "jenkins_triggered"
+	// EventReset tells the client that events it missed are not known
+	// anymore and it has to fetch the cache again
+	EventReset = "reset"
+)
+
+type Event struct {
+	ID         uint64                 `json:"id"`
+	Type       string                 `json:"type"`
+	Time       time.Time              `json:"time"`
+	Repository string                 `json:"repository"`
+	Number     int                    `json:"number"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventBus keeps a bounded backlog of events and fans them out to
+// subscribers. Publish never blocks so it is safe to call it with the cache
+// lock held. IDs start over on restart so they are sent with the boot id.
+type EventBus struct {
+	mu          sync.Mutex
+	boot        string
+	nextID      uint64
+	backlog     []*Event
+	backlogSize int
+	subscribers map[chan *Event]bool
+}
+
+func NewEventBus(backlogSize int, boot string) *EventBus {
+	if backlogSize <= 0 {
+		backlogSize = 1000
+	}
+	return &EventBus{
+		boot:        boot,
+		nextID:      1,
+		backlog:     []*Event{},
+		backlogSize: backlogSize,
+		subscribers: map[chan *Event]bool{},
+	}
+}
+
+func (bus *EventBus) Publish(eventType string, repo string, num int, data map[string]interface{}) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	e := &Event{
+		ID:         bus.nextID,
+		Type:       eventType,
+		Time:       time.Now(),
+		Repository: repo,
+		Number:     num,
+		Data:       data,
+	}
+	bus.nextID++
+
+	bus.backlog = append(bus.backlog, e)
+	if len(bus.backlog) > bus.backlogSize {
+		bus.backlog = bus.backlog[len(bus.backlog)-bus.backlogSize:]
+	}
+
+	for ch := range bus.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// slow subscriber - drop it, it can resume with Last-Event-ID
+			delete(bus.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel with new events and events from the backlog
+// that come after lastID. When some of them are not in the backlog anymore
+// or lastID is from another boot, a single reset event is returned instead.
+func (bus *EventBus) Subscribe(boot string, lastID uint64) (chan *Event, []*Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	missed := []*Event{}
+	for _, e := range bus.backlog {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	complete := lastID+1 == bus.nextID || (len(bus.backlog) > 0 && bus.backlog[0].ID <= lastID+1)
+	if boot != bus.boot || lastID >= bus.nextID || !complete {
+		missed = []*Event{{
+			ID:   bus.nextID - 1,
+			Type: EventReset,
+			Time: time.Now(),
+		}}
+	}
+
+	ch := make(chan *Event, 100)
+	bus.subscribers[ch] = true
+	return ch, missed
+}
+
+func (bus *EventBus) Unsubscribe(ch chan *Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	_, hasKey := bus.subscribers[ch]
+	if hasKey {
+		delete(bus.subscribers, ch)
+		close(ch)
+	}
+}
+
+// FormatID returns event ID as sent to the clients.
+func (bus *EventBus) FormatID(id uint64) string {
+	return fmt.Sprintf("%s-%d", bus.boot, id)
+}
+
+// ParseID splits event ID sent by a client into the boot id and the event
+// number.
+func (bus *EventBus) ParseID(s string) (string, uint64, error) {
+	boot := ""
+	i := strings.LastIndex(s, "-")
+	if i >= 0 {
+		boot = s[:i]
+	}
+	id, err := strconv.ParseUint(s[i+1:], 10, 64)
+	return boot, id, err
+}
+
+func (app *App) writeEvent(w http.ResponseWriter, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", app.events.FormatID(e.ID), e.Type, string(b))
+	return err
+}
+
+func (app *App) apiHandlerEvents(w http.ResponseWriter, r *http.Request) {
+	if !app.checkAPIToken(w, r) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	boot := ""
+	lastID := uint64(0)
+	if lastEventID != "" {
+		var err error
+		boot, lastID, err = app.events.ParseID(lastEventID)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ch, missed := app.events.Subscribe(boot, lastID)
+	defer app.events.Unsubscribe(ch)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// only replay when client is resuming, new clients get new events only
+	if lastEventID != "" {
+		for _, e := range missed {
+			if app.writeEvent(w, e) != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				log.Print("Dropping slow events subscriber")
+				return
+			}
+			if app.writeEvent(w, e) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSubscribeResetsWhenEventsAreNotKnown(t *testing.T) {
+	bus := NewEventBus(2, "boot1")
+	for i := 0; i < 3; i++ {
+		bus.Publish(EventPullRequestAdded, "repo-a", i, nil)
+	}
+
+	tests := []struct {
+		boot     string
+		lastID   uint64
+		expected []uint64
+		reset    bool
+	}{
+		{"boot1", 1, []uint64{2, 3}, false},
+		{"boot1", 3, []uint64{}, false},
+		{"boot1", 0, []uint64{3}, true},
+		{"boot0", 1, []uint64{3}, true},
+		{"boot1", 4, []uint64{3}, true},
+	}
+	for _, test := range tests {
+		ch, missed := bus.Subscribe(test.boot, test.lastID)
+		bus.Unsubscribe(ch)
+		if len(missed) != len(test.expected) {
+			t.Fatalf("%s-%d: expected %v, got %d events", test.boot, test.lastID, test.expected, len(missed))
+		}
+		for i, e := range missed {
+			if e.ID != test.expected[i] || (e.Type == EventReset) != test.reset {
+				t.Fatalf("%s-%d: unexpected event %v", test.boot, test.lastID, e)
+			}
+		}
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	bus := NewEventBus(2, "boot1")
+	boot, id, err := bus.ParseID(bus.FormatID(12))
+	if err != nil || boot != "boot1" || id != 12 {
+		t.Fatalf("unexpected %s, %d, %v", boot, id, err)
+	}
+	// IDs from before boot ids were sent
+	boot, id, err = bus.ParseID("12")
+	if err != nil || boot != "" || id != 12 {
+		t.Fatalf("unexpected %s, %d, %v", boot, id, err)
+	}
+	_, _, err = bus.ParseID("boot1-x")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
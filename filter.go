@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// CacheFilter narrows down pull requests returned by the API. Nil and empty
+// fields are not checked.
+type CacheFilter struct {
+	Repository      *regexp.Regexp
+	HasDependencies *bool
+	HasDependents   *bool
+	Blocked         *bool
+	Ready           *bool
+	Author          string
+	Label           string
+}
+
+func NewCacheFilterFromQuery(q url.Values) (*CacheFilter, error) {
+	f := &CacheFilter{
+		Author: q.Get("author"),
+		Label:  q.Get("label"),
+	}
+	if q.Get("repository") != "" {
+		re, err := regexp.Compile(q.Get("repository"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid repository regular expression: %s", err.Error())
+		}
+		f.Repository = re
+	}
+	bools := map[string]**bool{
+		"has_dependencies": &f.HasDependencies,
+		"has_dependents":   &f.HasDependents,
+		"blocked":          &f.Blocked,
+		"ready":            &f.Ready,
+	}
+	for n, ptr := range bools {
+		if q.Get(n) == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(q.Get(n))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value of %s", n)
+		}
+		*ptr = &b
+	}
+	return f, nil
+}
+
+// MatchesRepository checks the repository regular expression only.
+func (f *CacheFilter) MatchesRepository(repo string) bool {
+	if f == nil || f.Repository == nil {
+		return true
+	}
+	return f.Repository.MatchString(repo)
+}
+
+// Matches returns true when the cached pull request passes all the filters.
+// Caller must hold the cache lock.
+func (f *CacheFilter) Matches(c *Cache, repo string, num int) bool {
+	if f == nil {
+		return true
+	}
+	if !f.MatchesRepository(repo) {
+		return false
+	}
+	if f.HasDependencies != nil && *f.HasDependencies != (len(c.Dependencies[repo][num]) > 0) {
+		return false
+	}
+	if f.HasDependents != nil && *f.HasDependents != (len(c.Dependents[repo][num]) > 0) {
+		return false
+	}
+	blocked := c.IsBlocked(repo, num)
+	if f.Blocked != nil && *f.Blocked != blocked {
+		return false
+	}
+	if f.Ready != nil && *f.Ready != c.IsReady(repo, num) {
+		return false
+	}
+	details := c.PullRequests[repo][num]
+	if f.Author != "" && (details == nil || details.Author != f.Author) {
+		return false
+	}
+	if f.Label != "" && (details == nil || !details.HasLabel(f.Label)) {
+		return false
+	}
+	return true
+}
+
+// IsBlocked returns true when any of the pull request dependencies is still
+// open or is a draft that blocks dependents. Dependencies that are no longer
+// cached are considered merged. Caller must hold the cache lock.
+func (c *Cache) IsBlocked(repo string, num int) bool {
+	if len(c.BlockingDrafts(repo, num)) > 0 {
+		return true
+	}
+	for r, n := range c.Dependencies[repo][num] {
+		_, hasKey := c.Branches[r][n]
+		if hasKey {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDraft returns true when the pull request is a draft. Caller must hold the
+// cache lock.
+func (c *Cache) IsDraft(repo string, num int) bool {
+	details := c.PullRequests[repo][num]
+	return details != nil && details.Draft
+}
+
+// DraftDependencies returns dependencies that are still drafts. Caller must
+// hold the cache lock.
+func (c *Cache) DraftDependencies(repo string, num int) []string {
+	drafts := []string{}
+	for _, k := range dependencyList(c.Dependencies[repo][num]) {
+		r, n, _ := splitGraphNodeKey(k)
+		_, isOpen := c.Branches[r][n]
+		if isOpen && c.IsDraft(r, n) {
+			drafts = append(drafts, k)
+		}
+	}
+	return drafts
+}
+
+// BlockingDrafts returns draft dependencies when drafts are configured to
+// block their dependents. Caller must hold the cache lock.
+func (c *Cache) BlockingDrafts(repo string, num int) []string {
+	if !c.DraftsBlockDependents {
+		return []string{}
+	}
+	return c.DraftDependencies(repo, num)
+}
+
+// IsReady returns true when the pull request has dependencies and all of them
+// are merged. Caller must hold the cache lock.
+func (c *Cache) IsReady(repo string, num int) bool {
+	return len(c.Dependencies[repo][num]) > 0 && !c.IsBlocked(repo, num)
+}
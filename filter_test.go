@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDraftDependencyBlocksEverywhere(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	app.cfg.Statuses.Enabled = true
+	app.cfg.Labels.SetDefaults()
+	app.cache.DraftsBlockDependents = true
+	draft := testPullRequest("repo-b", 2)
+	draft.Details.Draft = true
+	openPullRequests(app, testPullRequest("repo-a", 1, "repo-b#2"), draft)
+
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+	if !app.getPullRequestState("repo-a", 1).Blocked {
+		t.Fatalf("expected the pull request state to be blocked")
+	}
+	for q, expected := range map[string]bool{"blocked=true": true, "ready=true": false} {
+		values, _ := url.ParseQuery(q)
+		f, err := NewCacheFilterFromQuery(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Matches(&app.cache, "repo-a", 1) != expected {
+			t.Fatalf("expected %s to match %t", q, expected)
+		}
+	}
+	if !app.getDesiredLabels("repo-a", 1)[app.cfg.Labels.Blocked] {
+		t.Fatalf("expected the blocked label")
+	}
+	if app.publishedStatuses["repo-a@repo-a-sha|pullrequestd/dependencies"] != StatusFailure+"|Depends on draft pull requests: repo-b#2" {
+		t.Fatalf("unexpected dependencies status %q", app.publishedStatuses["repo-a@repo-a-sha|pullrequestd/dependencies"])
+	}
+}
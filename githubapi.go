@@ -1,14 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type PullRequest struct {
@@ -17,46 +21,169 @@ type PullRequest struct {
 	Number     int
 	Branch     string
 	DependsOn  []string
+	Details    *PullRequestDetails
 }
 
 type GitHubAPI struct {
+	// MaxPages limits number of pages fetched from list endpoints, 0 means
+	// no limit
+	MaxPages   int
+	BaseURL    string
+	GraphQLURL string
+	// WebURL is where pull requests and repositories are browsed
+	WebURL     string
+	HTTPClient *http.Client
+	Transport  *GitHubTransport
 }
 
 func NewGitHubAPI() *GitHubAPI {
-	githubapi := &GitHubAPI{}
+	transport := NewGitHubTransport(http.DefaultTransport)
+	githubapi := &GitHubAPI{
+		BaseURL:    "https://api.github.com",
+		GraphQLURL: "https://api.github.com/graphql",
+		WebURL:     "https://github.com",
+		HTTPClient: &http.Client{Transport: transport},
+		Transport:  transport,
+	}
 	return githubapi
 }
 
-func (githubapi *GitHubAPI) GetRepositoriesList(owner string, organization bool, token string) ([]string, error) {
-	ownerType := "users"
-	if organization {
-		ownerType = "orgs"
+// Configure points the client at the configured GitHub instance, eg. GitHub
+// Enterprise Server, and sets up its CA bundle and proxy.
+func (githubapi *GitHubAPI) Configure(cfg *GitHub) error {
+	if cfg.APIURL != "" {
+		githubapi.BaseURL = strings.TrimSuffix(cfg.APIURL, "/")
+		// GHES serves API under /api/v3 and the rest under /api
+		if strings.HasSuffix(githubapi.BaseURL, "/api/v3") {
+			root := strings.TrimSuffix(githubapi.BaseURL, "/v3")
+			githubapi.GraphQLURL = root + "/graphql"
+			githubapi.WebURL = strings.TrimSuffix(root, "/api")
+		} else {
+			githubapi.GraphQLURL = githubapi.BaseURL + "/graphql"
+			// api.HOST serves the API of HOST
+			githubapi.WebURL = strings.Replace(githubapi.BaseURL, "://api.", "://", 1)
+		}
 	}
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/%s/%s/repos?per_page=100", ownerType, owner), strings.NewReader(""))
-	if err != nil {
-		return []string{}, err
+	if cfg.WebURL != "" {
+		githubapi.WebURL = strings.TrimSuffix(cfg.WebURL, "/")
+	}
+	if cfg.GraphQLURL != "" {
+		githubapi.GraphQLURL = cfg.GraphQLURL
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Add("Accept", "application/vnd.github.v3+json")
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CABundle != "" {
+		pem, err := ioutil.ReadFile(cfg.CABundle)
+		if err != nil {
+			return fmt.Errorf("Error reading CA bundle: %s", err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("CA bundle contains no certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return fmt.Errorf("Error parsing proxy URL: %s", err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	githubapi.Transport.Base = transport
+	if cfg.MaxRetries > 0 {
+		githubapi.Transport.MaxRetries = cfg.MaxRetries
+	}
+	return nil
+}
 
-	c := &http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return []string{}, err
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// getNextPageURL returns URL of the next page from the Link header or empty
+// string if this is the last page.
+func getNextPageURL(link string) string {
+	m := linkNextRegexp.FindStringSubmatch(link)
+	if m == nil {
+		return ""
 	}
+	return m[1]
+}
 
-	defer resp.Body.Close()
-	b, _ := ioutil.ReadAll(resp.Body)
+// getPaginated requests a list endpoint and follows Link headers until the
+// last page or the page limit is reached, returning items from all pages.
+func (githubapi *GitHubAPI) getPaginated(u string, token string) ([]interface{}, error) {
+	return githubapi.getPaginatedUntil(u, token, nil)
+}
 
-	var j interface{}
-	err = json.Unmarshal(b, &j)
+// getPaginatedUntil works like getPaginated but stops before the first item
+// for which stop returns true, for lists sorted from the newest.
+func (githubapi *GitHubAPI) getPaginatedUntil(u string, token string, stop func(item interface{}) bool) ([]interface{}, error) {
+	items := []interface{}{}
+	page := 0
+	for u != "" {
+		if githubapi.MaxPages > 0 && page >= githubapi.MaxPages {
+			log.Print(fmt.Sprintf("Reached limit of %d pages, next page %s is not fetched", githubapi.MaxPages, u))
+			break
+		}
+		page++
+
+		req, err := http.NewRequest("GET", u, strings.NewReader(""))
+		if err != nil {
+			return items, err
+		}
+
+		req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+		req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+		c := githubapi.HTTPClient
+		resp, err := c.Do(req)
+		if err != nil {
+			return items, err
+		}
+
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return items, fmt.Errorf("Got HTTP %d from %s", resp.StatusCode, u)
+		}
+
+		var j interface{}
+		err = json.Unmarshal(b, &j)
+		if err != nil {
+			return items, errors.New("Got non-JSON list")
+		}
+		l, ok := j.([]interface{})
+		if !ok {
+			return items, fmt.Errorf("Got unexpected response from %s", u)
+		}
+		for _, item := range l {
+			if stop != nil && stop(item) {
+				return items, nil
+			}
+			items = append(items, item)
+		}
+
+		u = getNextPageURL(resp.Header.Get("Link"))
+	}
+	return items, nil
+}
+
+func (githubapi *GitHubAPI) GetRepositoriesList(owner string, organization bool, token string) ([]string, error) {
+	ownerType := "users"
+	if organization {
+		ownerType = "orgs"
+	}
+	j, err := githubapi.getPaginated(fmt.Sprintf("%s/%s/%s/repos?per_page=100", githubapi.BaseURL, ownerType, owner), token)
 	if err != nil {
-		return []string{}, errors.New("Got non-JSON pulls")
+		return []string{}, err
 	}
 
 	repos := []string{}
-	for _, v := range j.([]interface{}) {
+	for _, v := range j {
 		if v.(map[string]interface{})["name"] != "" {
 			repos = append(repos, v.(map[string]interface{})["name"].(string))
 			log.Print(fmt.Sprintf("Found repository %s in owner %s", v.(map[string]interface{})["name"].(string), owner))
@@ -67,31 +194,13 @@ func (githubapi *GitHubAPI) GetRepositoriesList(owner string, organization bool,
 }
 
 func (githubapi *GitHubAPI) GetPullRequestList(owner string, repo string, token string) ([]PullRequest, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls?state=open&per_page=100", owner, repo), strings.NewReader(""))
+	j, err := githubapi.getPaginated(fmt.Sprintf("%s/repos/%s/%s/pulls?state=open&per_page=100", githubapi.BaseURL, owner, repo), token)
 	if err != nil {
 		return []PullRequest{}, err
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
-	req.Header.Add("Accept", "application/vnd.github.v3+json")
-
-	c := &http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return []PullRequest{}, err
-	}
-
-	defer resp.Body.Close()
-	b, _ := ioutil.ReadAll(resp.Body)
-
-	var j interface{}
-	err = json.Unmarshal(b, &j)
-	if err != nil {
-		return []PullRequest{}, errors.New("Got non-JSON pulls")
-	}
-
 	pulls := []PullRequest{}
-	for _, v := range j.([]interface{}) {
+	for _, v := range j {
 		if v.(map[string]interface{})["number"] != "" {
 			number := int(v.(map[string]interface{})["number"].(float64))
 			log.Print(fmt.Sprintf("Found open pull request %d in repo %s/%s", number, owner, repo))
@@ -103,12 +212,15 @@ func (githubapi *GitHubAPI) GetPullRequestList(owner string, repo string, token
 
 			dependsOn := githubapi.getDependsOnLinesFromBody(body)
 
+			details := newPullRequestDetailsFromJSON(v.(map[string]interface{}))
+
 			pulls = append(pulls, PullRequest{
 				Owner:      owner,
 				Repository: repo,
 				Number:     number,
 				Branch:     branch,
 				DependsOn:  dependsOn,
+				Details:    details,
 			})
 		}
 	}
@@ -128,3 +240,382 @@ func (githubapi *GitHubAPI) getDependsOnLinesFromBody(body string) []string {
 	}
 	return dependsOnLines
 }
+
+func (githubapi *GitHubAPI) CreateStatus(owner string, repo string, sha string, state string, context string, description string, token string) error {
+	body, err := json.Marshal(map[string]string{
+		"state":       state,
+		"context":     context,
+		"description": description,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/repos/%s/%s/statuses/%s", githubapi.BaseURL, owner, repo, sha), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Got HTTP %d when creating status on %s/%s@%s", resp.StatusCode, owner, repo, sha)
+	}
+	return nil
+}
+
+func (githubapi *GitHubAPI) AddLabels(owner string, repo string, num int, labels []string, token string) error {
+	body, err := json.Marshal(map[string][]string{
+		"labels": labels,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/repos/%s/%s/issues/%d/labels", githubapi.BaseURL, owner, repo, num), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Got HTTP %d when adding labels to %s/%s#%d", resp.StatusCode, owner, repo, num)
+	}
+	return nil
+}
+
+func (githubapi *GitHubAPI) RemoveLabel(owner string, repo string, num int, label string, token string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/repos/%s/%s/issues/%d/labels/%s", githubapi.BaseURL, owner, repo, num, url.PathEscape(label)), strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// label might have been removed manually in the meantime
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Got HTTP %d when removing label %s from %s/%s#%d", resp.StatusCode, label, owner, repo, num)
+	}
+	return nil
+}
+
+// GetPullRequestReviews returns the latest review state of each reviewer.
+func (githubapi *GitHubAPI) GetPullRequestReviews(owner string, repo string, num int, token string) (map[string]string, error) {
+	l, err := githubapi.getPaginated(fmt.Sprintf("%s/repos/%s/%s/pulls/%d/reviews?per_page=100", githubapi.BaseURL, owner, repo, num), token)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	// reviews are returned in chronological order
+	reviews := map[string]string{}
+	for _, v := range l {
+		user := getJSONString(v.(map[string]interface{}), "user", "login")
+		state := strings.ToLower(getJSONString(v.(map[string]interface{}), "state"))
+		setReviewState(reviews, user, state)
+	}
+	return reviews, nil
+}
+
+// getObject requests an endpoint returning a single JSON object.
+func (githubapi *GitHubAPI) getObject(u string, token string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", u, strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got HTTP %d from %s", resp.StatusCode, u)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, fmt.Errorf("Got non-JSON response from %s", u)
+	}
+	return j, nil
+}
+
+// GetCommitCIResults returns commit statuses, check runs and check suites of
+// the commit keyed the same way as they are when received in webhooks.
+func (githubapi *GitHubAPI) GetCommitCIResults(owner string, repo string, sha string, token string) ([]CIResult, error) {
+	u := fmt.Sprintf("%s/repos/%s/%s/commits/%s", githubapi.BaseURL, owner, repo, sha)
+	results := []CIResult{}
+
+	j, err := githubapi.getObject(u+"/status?per_page=100", token)
+	if err != nil {
+		return results, err
+	}
+	if l, ok := j["statuses"].([]interface{}); ok {
+		for _, v := range l {
+			results = append(results, CIResult{
+				Context: getJSONString(v.(map[string]interface{}), "context"),
+				State:   getJSONString(v.(map[string]interface{}), "state"),
+			})
+		}
+	}
+
+	j, err = githubapi.getObject(u+"/check-runs?per_page=100", token)
+	if err != nil {
+		return results, err
+	}
+	if l, ok := j["check_runs"].([]interface{}); ok {
+		for _, v := range l {
+			run := v.(map[string]interface{})
+			results = append(results, CIResult{
+				Context: "check_run/" + getJSONString(run, "name"),
+				State:   normalizeCheckState(getJSONString(run, "status"), getJSONString(run, "conclusion")),
+			})
+		}
+	}
+
+	j, err = githubapi.getObject(u+"/check-suites?per_page=100", token)
+	if err != nil {
+		return results, err
+	}
+	if l, ok := j["check_suites"].([]interface{}); ok {
+		for _, v := range l {
+			suite := v.(map[string]interface{})
+			results = append(results, CIResult{
+				Context: "check_suite/" + getJSONString(suite, "app", "slug"),
+				State:   normalizeCheckState(getJSONString(suite, "status"), getJSONString(suite, "conclusion")),
+			})
+		}
+	}
+	return results, nil
+}
+
+// GetRepositoryName returns current name of the repository, GitHub redirects
+// requests made with an old name of a renamed one.
+func (githubapi *GitHubAPI) GetRepositoryName(owner string, repo string, token string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/%s", githubapi.BaseURL, owner, repo), strings.NewReader(""))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Got HTTP %d when getting %s/%s", resp.StatusCode, owner, repo)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return "", errors.New("Got non-JSON repository")
+	}
+	return getJSONString(j, "name"), nil
+}
+
+// GetPullRequest returns details of a single pull request, including the
+// mergeable state which is not returned in lists, and whether it is merged.
+func (githubapi *GitHubAPI) GetPullRequest(owner string, repo string, num int, token string) (*PullRequestDetails, bool, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/%s/pulls/%d", githubapi.BaseURL, owner, repo, num), strings.NewReader(""))
+	if err != nil {
+		return nil, false, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("Got HTTP %d when getting %s/%s#%d", resp.StatusCode, owner, repo, num)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, false, errors.New("Got non-JSON pull request")
+	}
+
+	merged := false
+	if j["merged"] != nil {
+		merged = j["merged"].(bool)
+	}
+	return newPullRequestDetailsFromJSON(j), merged, nil
+}
+
+// MergePullRequest merges pull request if its head is still at sha.
+func (githubapi *GitHubAPI) MergePullRequest(owner string, repo string, num int, sha string, method string, token string) error {
+	body, err := json.Marshal(map[string]string{
+		"sha":          sha,
+		"merge_method": method,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/repos/%s/%s/pulls/%d/merge", githubapi.BaseURL, owner, repo, num), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		j := map[string]interface{}{}
+		json.Unmarshal(b, &j)
+		return fmt.Errorf("Got HTTP %d when merging %s/%s#%d: %s", resp.StatusCode, owner, repo, num, getJSONString(j, "message"))
+	}
+	return nil
+}
+
+func (githubapi *GitHubAPI) CreateComment(owner string, repo string, num int, comment string, token string) error {
+	body, err := json.Marshal(map[string]string{
+		"body": comment,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", githubapi.BaseURL, owner, repo, num), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Got HTTP %d when commenting on %s/%s#%d", resp.StatusCode, owner, repo, num)
+	}
+	return nil
+}
+
+// GetInstallationID returns ID of the GitHub App installation on the owner.
+// It is authenticated with the app JWT instead of a token.
+func (githubapi *GitHubAPI) GetInstallationID(owner string, organization bool, jwt string) (int64, error) {
+	ownerType := "users"
+	if organization {
+		ownerType = "orgs"
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s/installation", githubapi.BaseURL, ownerType, owner), strings.NewReader(""))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Got HTTP %d when getting installation for %s", resp.StatusCode, owner)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil || j["id"] == nil {
+		return 0, errors.New("Got invalid installation")
+	}
+	return int64(j["id"].(float64)), nil
+}
+
+// CreateInstallationToken exchanges the app JWT for an installation token.
+func (githubapi *GitHubAPI) CreateInstallationToken(installationID int64, jwt string) (string, time.Time, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/app/installations/%d/access_tokens", githubapi.BaseURL, installationID), strings.NewReader(""))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("Got HTTP %d when creating token for installation %d", resp.StatusCode, installationID)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return "", time.Time{}, errors.New("Got non-JSON installation token")
+	}
+	expiresAt, err := time.Parse(time.RFC3339, getJSONString(j, "expires_at"))
+	if err != nil {
+		return "", time.Time{}, errors.New("Got invalid expiry of installation token")
+	}
+	return getJSONString(j, "token"), expiresAt, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestURLsFollowConfiguredEnterpriseServer(t *testing.T) {
+	requested := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	app := newTestApp(t, "repo-a")
+	err := app.githubAPI.Configure(&GitHub{APIURL: srv.URL + "/api/v3/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.githubAPI.BaseURL != srv.URL+"/api/v3" || app.githubAPI.GraphQLURL != srv.URL+"/api/graphql" || app.githubAPI.WebURL != srv.URL {
+		t.Fatalf("unexpected URLs %s, %s, %s", app.githubAPI.BaseURL, app.githubAPI.GraphQLURL, app.githubAPI.WebURL)
+	}
+
+	_, err = app.githubAPI.GetPullRequestList("owner1", "repo-a", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested != "/api/v3/repos/owner1/repo-a/pulls" {
+		t.Fatalf("unexpected request to %s", requested)
+	}
+
+	openPullRequests(app, testPullRequest("repo-a", 1))
+	if app.getPullRequestURL("repo-a", 1) != srv.URL+"/owner1/repo-a/pull/1" {
+		t.Fatalf("unexpected pull request URL %s", app.getPullRequestURL("repo-a", 1))
+	}
+	app.cache.mu.Lock()
+	cloneURL := app.replacePathWithRepoAndNum("{{.head_clone_url}}", "repo-a", 1)
+	app.cache.mu.Unlock()
+	if cloneURL != url.QueryEscape(srv.URL+"/owner1/repo-a.git") {
+		t.Fatalf("unexpected clone URL %s", cloneURL)
+	}
+}
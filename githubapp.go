@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// GitHubAppAuth authenticates as a GitHub App and keeps installation tokens
+// per owner, refreshing them before they expire.
+type GitHubAppAuth struct {
+	appID         string
+	key           *rsa.PrivateKey
+	githubAPI     *GitHubAPI
+	mu            sync.Mutex
+	installations map[string]int64
+	tokens        map[string]*installationToken
+}
+
+func NewGitHubAppAuth(appID string, privateKeyPath string, githubAPI *GitHubAPI) (*GitHubAppAuth, error) {
+	b, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading GitHub App private key: %s", err.Error())
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("GitHub App private key is not PEM encoded")
+	}
+
+	var key *rsa.PrivateKey
+	key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err2 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err2 != nil {
+			return nil, errors.New("GitHub App private key cannot be parsed")
+		}
+		var ok bool
+		key, ok = parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("GitHub App private key is not an RSA key")
+		}
+	}
+
+	return &GitHubAppAuth{
+		appID:         appID,
+		key:           key,
+		githubAPI:     githubAPI,
+		installations: map[string]int64{},
+		tokens:        map[string]*installationToken{},
+	}, nil
+}
+
+// getJWT returns a short lived token signed with the app private key.
+func (auth *GitHubAppAuth) getJWT() (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	// issued in the past to allow for clock drift
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": auth.appID,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, auth.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// SetInstallation remembers installation of the owner, eg. from a webhook
+// payload, so it does not have to be looked up.
+func (auth *GitHubAppAuth) SetInstallation(owner string, installationID int64) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	if auth.installations[owner] != installationID {
+		auth.installations[owner] = installationID
+		delete(auth.tokens, owner)
+	}
+}
+
+// GetToken returns installation token for the owner.
+func (auth *GitHubAppAuth) GetToken(owner string, organization bool) (string, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	t := auth.tokens[owner]
+	if t != nil && time.Now().Add(5*time.Minute).Before(t.expiresAt) {
+		return t.token, nil
+	}
+
+	jwt, err := auth.getJWT()
+	if err != nil {
+		return "", err
+	}
+
+	installationID, hasKey := auth.installations[owner]
+	if !hasKey {
+		installationID, err = auth.githubAPI.GetInstallationID(owner, organization, jwt)
+		if err != nil {
+			return "", err
+		}
+		auth.installations[owner] = installationID
+	}
+
+	token, expiresAt, err := auth.githubAPI.CreateInstallationToken(installationID, jwt)
+	if err != nil {
+		return "", err
+	}
+	log.Print(fmt.Sprintf("Got GitHub App installation token for %s valid until %s", owner, expiresAt.String()))
+	auth.tokens[owner] = &installationToken{
+		token:     token,
+		expiresAt: expiresAt,
+	}
+	return token, nil
+}
+
+// getGitHubToken returns token to authenticate GitHub API requests with,
+// either personal access token from the config or a GitHub App installation
+// token.
+func (app *App) getGitHubToken() string {
+	if app.githubAppAuth == nil {
+		return app.cfg.Token
+	}
+	token, err := app.githubAppAuth.GetToken(app.cfg.PullRequestDependsOn.Owner, app.cfg.PullRequestDependsOn.Organization)
+	if err != nil {
+		log.Print("Error getting GitHub App installation token: " + err.Error())
+	}
+	return token
+}
+
+// processPayloadInstallation routes webhook to its GitHub App installation.
+func (app *App) processPayloadInstallation(j map[string]interface{}) {
+	if app.githubAppAuth == nil || j["installation"] == nil {
+		return
+	}
+	if j["installation"].(map[string]interface{})["id"] == nil {
+		return
+	}
+	owner := getJSONString(j, "repository", "owner", "login")
+	if owner == "" {
+		owner = getJSONString(j, "installation", "account", "login")
+	}
+	if owner == "" {
+		return
+	}
+	app.githubAppAuth.SetInstallation(owner, int64(j["installation"].(map[string]interface{})["id"].(float64)))
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maximum number of responses kept for conditional requests
+const etagCacheSize = 2000
+
+type cachedResponse struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// GitHubTransport is shared by all GitHub API requests. It waits when the
+// rate limit is exhausted, backs off on secondary rate limits, retries
+// transient server errors of idempotent requests and sends If-None-Match with cached ETags so that
+// unchanged data does not count against the quota.
+type GitHubTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int
+
+	mu                 sync.Mutex
+	etags              map[string]*cachedResponse
+	rateLimitLimit     int
+	rateLimitRemaining int
+	rateLimitReset     time.Time
+	notModified        uint64
+	retries            uint64
+}
+
+func NewGitHubTransport(base http.RoundTripper) *GitHubTransport {
+	return &GitHubTransport{
+		Base:               base,
+		MaxRetries:         3,
+		etags:              map[string]*cachedResponse{},
+		rateLimitRemaining: -1,
+	}
+}
+
+// RateLimit returns the last known quota, remaining is -1 when no response
+// has been received yet.
+func (t *GitHubTransport) RateLimit() (int, int, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rateLimitLimit, t.rateLimitRemaining, t.rateLimitReset
+}
+
+// Counters returns number of requests answered from the ETag cache and
+// number of retried requests.
+func (t *GitHubTransport) Counters() (uint64, uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.notModified, t.retries
+}
+
+func (t *GitHubTransport) updateRateLimit(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rateLimitRemaining = remaining
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err == nil {
+		t.rateLimitLimit = limit
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err == nil {
+		t.rateLimitReset = time.Unix(reset, 0)
+	}
+}
+
+// waitForRateLimit blocks until the quota is reset when it is exhausted.
+func (t *GitHubTransport) waitForRateLimit() {
+	t.mu.Lock()
+	wait := time.Duration(0)
+	if t.rateLimitRemaining == 0 {
+		wait = time.Until(t.rateLimitReset) + time.Second
+	}
+	t.mu.Unlock()
+
+	if wait > 0 {
+		log.Print(fmt.Sprintf("GitHub rate limit exhausted, waiting %s", wait.Round(time.Second).String()))
+		time.Sleep(wait)
+		t.mu.Lock()
+		if time.Now().After(t.rateLimitReset) {
+			t.rateLimitRemaining = -1
+		}
+		t.mu.Unlock()
+	}
+}
+
+// isIdempotent returns true when sending the request again cannot repeat its
+// effect, eg. post a second comment or merge twice. GraphQL queries are sent
+// with POST but only read.
+func isIdempotent(req *http.Request) bool {
+	if req.Method == "GET" || req.Method == "HEAD" || req.Method == "DELETE" {
+		return true
+	}
+	return req.Method == "POST" && isGraphQLQuery(req)
+}
+
+// isGraphQLQuery returns true when the request is a GraphQL query, not a
+// mutation.
+func isGraphQLQuery(req *http.Request) bool {
+	if !strings.HasSuffix(req.URL.Path, "/graphql") || req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+	b, _ := ioutil.ReadAll(body)
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return false
+	}
+	// operation type can be left out for queries
+	query := strings.TrimSpace(getJSONString(j, "query"))
+	return strings.HasPrefix(query, "query") || strings.HasPrefix(query, "{")
+}
+
+// getRetryDelay returns how long to wait before retrying the request, or
+// false if the response should be returned to the caller. Rate limited
+// requests were not processed so they are retried regardless of the method.
+func (t *GitHubTransport) getRetryDelay(req *http.Request, resp *http.Response, body []byte, attempt int) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err == nil {
+			return time.Duration(retryAfter) * time.Second, true
+		}
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			return time.Until(t.rateLimitReset) + time.Second, true
+		}
+		if strings.Contains(strings.ToLower(string(body)), "secondary rate limit") {
+			return time.Minute << uint(attempt), true
+		}
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if isIdempotent(req) {
+			return time.Second << uint(attempt), true
+		}
+	}
+	return 0, false
+}
+
+func (t *GitHubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cacheable := req.Method == "GET"
+	cacheKey := req.Header.Get("Authorization") + " " + req.URL.String()
+
+	for attempt := 0; ; attempt++ {
+		t.waitForRateLimit()
+
+		r := req.Clone(req.Context())
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("Unable to retry request to %s", req.URL.String())
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+
+		var cached *cachedResponse
+		if cacheable {
+			t.mu.Lock()
+			cached = t.etags[cacheKey]
+			t.mu.Unlock()
+			if cached != nil {
+				r.Header.Set("If-None-Match", cached.etag)
+			}
+		}
+
+		resp, err := t.Base.RoundTrip(r)
+		if err != nil {
+			if attempt < t.MaxRetries && isIdempotent(req) {
+				t.retry(req, fmt.Sprintf("error %s", err.Error()), time.Second<<uint(attempt))
+				continue
+			}
+			return nil, err
+		}
+		t.updateRateLimit(resp)
+
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			resp.Body.Close()
+			t.mu.Lock()
+			t.notModified++
+			t.mu.Unlock()
+			return newCachedResponse(req, cached, resp), nil
+		}
+
+		if resp.StatusCode >= 400 {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			wait, retry := t.getRetryDelay(req, resp, body, attempt)
+			if retry && attempt < t.MaxRetries {
+				t.retry(req, fmt.Sprintf("HTTP %d", resp.StatusCode), wait)
+				continue
+			}
+			return resp, nil
+		}
+
+		etag := resp.Header.Get("ETag")
+		if cacheable && resp.StatusCode == http.StatusOK && etag != "" {
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			t.store(cacheKey, &cachedResponse{
+				etag:   etag,
+				header: resp.Header.Clone(),
+				body:   body,
+			})
+		}
+		return resp, nil
+	}
+}
+
+func (t *GitHubTransport) retry(req *http.Request, reason string, wait time.Duration) {
+	log.Print(fmt.Sprintf("Got %s from %s %s, retrying in %s", reason, req.Method, req.URL.String(), wait.Round(time.Second).String()))
+	t.mu.Lock()
+	t.retries++
+	t.mu.Unlock()
+	time.Sleep(wait)
+}
+
+func (t *GitHubTransport) store(key string, cached *cachedResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, hasKey := t.etags[key]; !hasKey && len(t.etags) >= etagCacheSize {
+		// drop a random entry, maps are iterated in random order
+		for k := range t.etags {
+			delete(t.etags, k)
+			break
+		}
+	}
+	t.etags[key] = cached
+}
+
+// newCachedResponse returns the cached body as if it was sent again, with the
+// rate limit headers of the 304 response.
+func newCachedResponse(req *http.Request, cached *cachedResponse, notModified *http.Response) *http.Response {
+	header := cached.header.Clone()
+	for k, v := range notModified.Header {
+		if strings.HasPrefix(k, "X-Ratelimit-") {
+			header[k] = v
+		}
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         notModified.Proto,
+		ProtoMajor:    notModified.ProtoMajor,
+		ProtoMinor:    notModified.ProtoMinor,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(cached.body)),
+		ContentLength: int64(len(cached.body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		method   string
+		url      string
+		body     string
+		expected bool
+	}{
+		{"GET", "https://api.github.com/repos/owner1/repo-a/pulls", "", true},
+		{"POST", "https://api.github.com/repos/owner1/repo-a/issues/1/comments", `{"body":"x"}`, false},
+		{"POST", "https://api.github.com/graphql", `{"query":"\nquery($owner: String!) { viewer { login } }"}`, true},
+		{"POST", "https://api.github.com/graphql", `{"query":"{ viewer { login } }"}`, true},
+		{"POST", "https://api.github.com/graphql", `{"query":"mutation { addStar(input: {}) { clientMutationId } }"}`, false},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isIdempotent(req) != test.expected {
+			t.Fatalf("expected %s %s with %s to be idempotent: %t", test.method, test.url, test.body, test.expected)
+		}
+	}
+}
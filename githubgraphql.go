@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const graphQLPullRequestFields = `
+	number
+	title
+	body
+	url
+	isDraft
+	createdAt
+	updatedAt
+	baseRefName
+	headRefName
+	headRefOid
+	author { login }
+	headRepository { nameWithOwner url }
+	labels(first: 100) {
+		pageInfo { hasNextPage endCursor }
+		nodes { name }
+	}
+`
+
+const graphQLRepositoriesQuery = `
+query($owner: String!, $cursor: String) {
+	repositoryOwner(login: $owner) {
+		repositories(first: 25, after: $cursor, ownerAffiliations: OWNER) {
+			pageInfo { hasNextPage endCursor }
+			nodes {
+				name
+				pullRequests(first: 50, states: OPEN) {
+					pageInfo { hasNextPage endCursor }
+					nodes {` + graphQLPullRequestFields + `}
+				}
+			}
+		}
+	}
+}`
+
+const graphQLPullRequestsQuery = `
+query($owner: String!, $repo: String!, $cursor: String) {
+	repository(owner: $owner, name: $repo) {
+		pullRequests(first: 100, after: $cursor, states: OPEN) {
+			pageInfo { hasNextPage endCursor }
+			nodes {` + graphQLPullRequestFields + `}
+		}
+	}
+}`
+
+// graphQL sends a query and returns its data.
+func (githubapi *GitHubAPI) graphQL(query string, variables map[string]interface{}, token string) (map[string]interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", githubapi.GraphQLURL, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got HTTP %d from GraphQL API", resp.StatusCode)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil {
+		return nil, errors.New("Got non-JSON GraphQL response")
+	}
+	if j["errors"] != nil {
+		msgs := []string{}
+		for _, e := range j["errors"].([]interface{}) {
+			msgs = append(msgs, getJSONString(e.(map[string]interface{}), "message"))
+		}
+		return nil, fmt.Errorf("Got GraphQL errors: %s", strings.Join(msgs, ", "))
+	}
+	data, ok := j["data"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Got GraphQL response without data")
+	}
+	return data, nil
+}
+
+// getGraphQLConnection returns nodes and the next page cursor of a connection
+// found under the keys.
+func getGraphQLConnection(j map[string]interface{}, keys ...string) ([]interface{}, string) {
+	for _, k := range keys {
+		m, ok := j[k].(map[string]interface{})
+		if !ok {
+			return []interface{}{}, ""
+		}
+		j = m
+	}
+	nodes, _ := j["nodes"].([]interface{})
+	if nodes == nil {
+		nodes = []interface{}{}
+	}
+	cursor := ""
+	pageInfo, _ := j["pageInfo"].(map[string]interface{})
+	if hasNextPage, _ := pageInfo["hasNextPage"].(bool); hasNextPage {
+		cursor = getJSONString(j, "pageInfo", "endCursor")
+	}
+	return nodes, cursor
+}
+
+// newPullRequestFromGraphQL converts a pull request node to the same shape as
+// the ones returned by the REST API.
+func (githubapi *GitHubAPI) newPullRequestFromGraphQL(owner string, repo string, node map[string]interface{}) PullRequest {
+	// managed labels that are not fetched would be added again
+	labels, labelsCursor := getGraphQLConnection(node, "labels")
+	if labelsCursor != "" {
+		log.Print(fmt.Sprintf("Pull request %s/%s#%d has more than %d labels, the rest is not fetched", owner, repo, int(node["number"].(float64)), len(labels)))
+	}
+	headCloneURL := ""
+	if getJSONString(node, "headRepository", "url") != "" {
+		headCloneURL = getJSONString(node, "headRepository", "url") + ".git"
+	}
+	rest := map[string]interface{}{
+		"title":      getJSONString(node, "title"),
+		"user":       map[string]interface{}{"login": getJSONString(node, "author", "login")},
+		"html_url":   getJSONString(node, "url"),
+		"draft":      node["isDraft"],
+		"created_at": getJSONString(node, "createdAt"),
+		"updated_at": getJSONString(node, "updatedAt"),
+		"labels":     labels,
+		"base": map[string]interface{}{
+			"ref":  getJSONString(node, "baseRefName"),
+			"repo": map[string]interface{}{"full_name": owner + "/" + repo},
+		},
+		"head": map[string]interface{}{
+			"sha": getJSONString(node, "headRefOid"),
+			"repo": map[string]interface{}{
+				"full_name": getJSONString(node, "headRepository", "nameWithOwner"),
+				"clone_url": headCloneURL,
+			},
+		},
+	}
+
+	number := int(node["number"].(float64))
+	log.Print(fmt.Sprintf("Found open pull request %d in repo %s/%s", number, owner, repo))
+	return PullRequest{
+		Owner:      owner,
+		Repository: repo,
+		Number:     number,
+		Branch:     getJSONString(node, "headRefName"),
+		DependsOn:  githubapi.getDependsOnLinesFromBody(getJSONString(node, "body")),
+		Details:    newPullRequestDetailsFromJSON(rest),
+	}
+}
+
+// GetRepositoriesWithPullRequests returns repositories of the owner together
+// with their open pull requests using a few GraphQL queries instead of one
+// REST request per repository. Pull requests of repositories for which
+// include returns false are neither returned nor paginated.
+func (githubapi *GitHubAPI) GetRepositoriesWithPullRequests(owner string, include func(repo string) bool, token string) ([]string, map[string][]PullRequest, error) {
+	repos := []string{}
+	pulls := map[string][]PullRequest{}
+	page := 0
+	cursor := ""
+	for {
+		if githubapi.MaxPages > 0 && page >= githubapi.MaxPages {
+			log.Print(fmt.Sprintf("Reached limit of %d pages, next page of repositories is not fetched", githubapi.MaxPages))
+			break
+		}
+		page++
+
+		variables := map[string]interface{}{"owner": owner}
+		if cursor != "" {
+			variables["cursor"] = cursor
+		}
+		data, err := githubapi.graphQL(graphQLRepositoriesQuery, variables, token)
+		if err != nil {
+			return repos, pulls, err
+		}
+		if data["repositoryOwner"] == nil {
+			return repos, pulls, fmt.Errorf("Owner %s not found", owner)
+		}
+
+		var nodes []interface{}
+		nodes, cursor = getGraphQLConnection(data, "repositoryOwner", "repositories")
+		for _, n := range nodes {
+			repo := getJSONString(n.(map[string]interface{}), "name")
+			repos = append(repos, repo)
+			log.Print(fmt.Sprintf("Found repository %s in owner %s", repo, owner))
+			if !include(repo) {
+				continue
+			}
+
+			prNodes, prCursor := getGraphQLConnection(n.(map[string]interface{}), "pullRequests")
+			pulls[repo] = []PullRequest{}
+			for _, pr := range prNodes {
+				pulls[repo] = append(pulls[repo], githubapi.newPullRequestFromGraphQL(owner, repo, pr.(map[string]interface{})))
+			}
+			if prCursor != "" {
+				more, err := githubapi.getPullRequestListGraphQL(owner, repo, prCursor, token)
+				if err != nil {
+					return repos, pulls, err
+				}
+				pulls[repo] = append(pulls[repo], more...)
+			}
+		}
+
+		if cursor == "" {
+			break
+		}
+	}
+	return repos, pulls, nil
+}
+
+// getPullRequestListGraphQL returns remaining open pull requests of a
+// repository that has more than fit in the repositories query.
+func (githubapi *GitHubAPI) getPullRequestListGraphQL(owner string, repo string, cursor string, token string) ([]PullRequest, error) {
+	pulls := []PullRequest{}
+	for cursor != "" {
+		data, err := githubapi.graphQL(graphQLPullRequestsQuery, map[string]interface{}{
+			"owner":  owner,
+			"repo":   repo,
+			"cursor": cursor,
+		}, token)
+		if err != nil {
+			return pulls, err
+		}
+
+		var nodes []interface{}
+		nodes, cursor = getGraphQLConnection(data, "repository", "pullRequests")
+		for _, pr := range nodes {
+			pulls = append(pulls, githubapi.newPullRequestFromGraphQL(owner, repo, pr.(map[string]interface{})))
+		}
+	}
+	return pulls, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLBootstrapSkipsExcludedRepositories(t *testing.T) {
+	queried := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		j := map[string]interface{}{}
+		json.Unmarshal(b, &j)
+		if strings.Contains(getJSONString(j, "query"), "repositoryOwner") {
+			w.Write([]byte(`{"data":{"repositoryOwner":{"repositories":{
+				"pageInfo":{"hasNextPage":false},
+				"nodes":[
+					{"name":"repo-a","pullRequests":{"pageInfo":{"hasNextPage":true,"endCursor":"a1"},"nodes":[{"number":1,"headRefName":"branch-repo-a","labels":{"pageInfo":{"hasNextPage":false},"nodes":[{"name":"bug"}]}}]}},
+					{"name":"repo-x","pullRequests":{"pageInfo":{"hasNextPage":true,"endCursor":"x1"},"nodes":[{"number":1,"headRefName":"branch-repo-x"}]}}
+				]
+			}}}}`))
+			return
+		}
+		queried = append(queried, j["variables"].(map[string]interface{})["repo"].(string))
+		w.Write([]byte(`{"data":{"repository":{"pullRequests":{"pageInfo":{"hasNextPage":false},"nodes":[{"number":2,"headRefName":"other"}]}}}}`))
+	}))
+	defer srv.Close()
+
+	api := NewGitHubAPI()
+	api.GraphQLURL = srv.URL + "/graphql"
+	repos, pulls, err := api.GetRepositoriesWithPullRequests("owner1", func(repo string) bool {
+		return repo != "repo-x"
+	}, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(repos, ",") != "repo-a,repo-x" {
+		t.Fatalf("unexpected repositories %v", repos)
+	}
+	if strings.Join(queried, ",") != "repo-a" {
+		t.Fatalf("expected only repo-a pull requests to be paginated, got %v", queried)
+	}
+	if len(pulls["repo-a"]) != 2 || pulls["repo-x"] != nil {
+		t.Fatalf("unexpected pull requests %v", pulls)
+	}
+	if !pulls["repo-a"][0].Details.HasLabel("bug") {
+		t.Fatalf("expected labels to be read")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Hook struct {
+	ID          int64
+	URL         string
+	ContentType string
+	InsecureSSL string
+	HasSecret   bool
+	Events      []string
+	Active      bool
+}
+
+type HookDelivery struct {
+	ID          int64
+	GUID        string
+	DeliveredAt time.Time
+	Redelivery  bool
+	StatusCode  int
+	Event       string
+	Action      string
+}
+
+// Success returns true when the delivery got a 2xx response.
+func (delivery *HookDelivery) Success() bool {
+	return delivery.StatusCode >= 200 && delivery.StatusCode < 300
+}
+
+// getHooksURL returns URL of organization hooks when repo is empty or of the
+// repository hooks otherwise.
+func (githubapi *GitHubAPI) getHooksURL(owner string, repo string) string {
+	if repo == "" {
+		return fmt.Sprintf("%s/orgs/%s/hooks", githubapi.BaseURL, owner)
+	}
+	return fmt.Sprintf("%s/repos/%s/%s/hooks", githubapi.BaseURL, owner, repo)
+}
+
+func newHookFromJSON(j map[string]interface{}) Hook {
+	hook := Hook{
+		URL:         getJSONString(j, "config", "url"),
+		ContentType: getJSONString(j, "config", "content_type"),
+		InsecureSSL: getJSONString(j, "config", "insecure_ssl"),
+		Events:      []string{},
+	}
+	// secret is never returned, only masked
+	hook.HasSecret = getJSONString(j, "config", "secret") != ""
+	if j["id"] != nil {
+		hook.ID = int64(j["id"].(float64))
+	}
+	if j["active"] != nil {
+		hook.Active = j["active"].(bool)
+	}
+	if j["events"] != nil {
+		for _, e := range j["events"].([]interface{}) {
+			hook.Events = append(hook.Events, e.(string))
+		}
+	}
+	return hook
+}
+
+// GetHooks returns webhooks of the organization when repo is empty or of the
+// repository otherwise.
+func (githubapi *GitHubAPI) GetHooks(owner string, repo string, token string) ([]Hook, error) {
+	l, err := githubapi.getPaginated(githubapi.getHooksURL(owner, repo)+"?per_page=100", token)
+	if err != nil {
+		return []Hook{}, err
+	}
+
+	hooks := []Hook{}
+	for _, v := range l {
+		hooks = append(hooks, newHookFromJSON(v.(map[string]interface{})))
+	}
+	return hooks, nil
+}
+
+// GetHookDeliveries returns deliveries of the hook made after since, newest
+// first.
+func (githubapi *GitHubAPI) GetHookDeliveries(owner string, repo string, hookID int64, since time.Time, token string) ([]HookDelivery, error) {
+	u := fmt.Sprintf("%s/%d/deliveries?per_page=100", githubapi.getHooksURL(owner, repo), hookID)
+	// deliveries are sorted from the newest
+	l, err := githubapi.getPaginatedUntil(u, token, func(item interface{}) bool {
+		deliveredAt, _ := time.Parse(time.RFC3339, getJSONString(item.(map[string]interface{}), "delivered_at"))
+		return deliveredAt.Before(since)
+	})
+	if err != nil {
+		return []HookDelivery{}, err
+	}
+
+	deliveries := []HookDelivery{}
+	for _, v := range l {
+		j := v.(map[string]interface{})
+		delivery := HookDelivery{
+			GUID:   getJSONString(j, "guid"),
+			Event:  getJSONString(j, "event"),
+			Action: getJSONString(j, "action"),
+		}
+		delivery.DeliveredAt, _ = time.Parse(time.RFC3339, getJSONString(j, "delivered_at"))
+		if j["id"] != nil {
+			delivery.ID = int64(j["id"].(float64))
+		}
+		if j["status_code"] != nil {
+			delivery.StatusCode = int(j["status_code"].(float64))
+		}
+		if j["redelivery"] != nil {
+			delivery.Redelivery = j["redelivery"].(bool)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// GetHookDeliveryPayload returns event name and payload sent in the delivery.
+func (githubapi *GitHubAPI) GetHookDeliveryPayload(owner string, repo string, hookID int64, deliveryID int64, token string) (string, []byte, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%d/deliveries/%d", githubapi.getHooksURL(owner, repo), hookID, deliveryID), strings.NewReader(""))
+	if err != nil {
+		return "", nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("Got HTTP %d when getting delivery %d of hook %d", resp.StatusCode, deliveryID, hookID)
+	}
+
+	j := map[string]interface{}{}
+	err = json.Unmarshal(b, &j)
+	if err != nil || j["request"] == nil {
+		return "", nil, errors.New("Got invalid delivery")
+	}
+	payload, err := json.Marshal(j["request"].(map[string]interface{})["payload"])
+	if err != nil {
+		return "", nil, err
+	}
+	return getJSONString(j, "event"), payload, nil
+}
+
+// RedeliverHookDelivery asks GitHub to send the delivery again.
+func (githubapi *GitHubAPI) RedeliverHookDelivery(owner string, repo string, hookID int64, deliveryID int64, token string) error {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%d/deliveries/%d/attempts", githubapi.getHooksURL(owner, repo), hookID, deliveryID), strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Got HTTP %d when redelivering %d of hook %d", resp.StatusCode, deliveryID, hookID)
+	}
+	return nil
+}
+
+func (githubapi *GitHubAPI) getHookBody(hook *Hook, secret string) ([]byte, error) {
+	config := map[string]string{
+		"url":          hook.URL,
+		"content_type": hook.ContentType,
+		"insecure_ssl": hook.InsecureSSL,
+	}
+	if secret != "" {
+		config["secret"] = secret
+	}
+	return json.Marshal(map[string]interface{}{
+		"name":   "web",
+		"active": hook.Active,
+		"events": hook.Events,
+		"config": config,
+	})
+}
+
+// CreateHook creates webhook on the organization when repo is empty or on
+// the repository otherwise.
+func (githubapi *GitHubAPI) CreateHook(owner string, repo string, hook *Hook, secret string, token string) error {
+	body, err := githubapi.getHookBody(hook, secret)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", githubapi.getHooksURL(owner, repo), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Got HTTP %d when creating hook on %s/%s", resp.StatusCode, owner, repo)
+	}
+	return nil
+}
+
+// UpdateHook replaces configuration of an existing webhook.
+func (githubapi *GitHubAPI) UpdateHook(owner string, repo string, hook *Hook, secret string, token string) error {
+	body, err := githubapi.getHookBody(hook, secret)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/%d", githubapi.getHooksURL(owner, repo), hook.ID), strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+
+	c := githubapi.HTTPClient
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Got HTTP %d when updating hook %d on %s/%s", resp.StatusCode, hook.ID, owner, repo)
+	}
+	return nil
+}
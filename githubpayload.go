@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type GitHubPayload struct {
@@ -24,6 +25,10 @@ func (githubPayload *GitHubPayload) GetSignature(r *http.Request) string {
 	return r.Header.Get("X-Hub-Signature")
 }
 
+func (githubPayload *GitHubPayload) GetDeliveryID(r *http.Request) string {
+	return r.Header.Get("X-GitHub-Delivery")
+}
+
 func (githubPayload *GitHubPayload) signBody(secret []byte, body []byte) []byte {
 	computed := hmac.New(sha1.New, secret)
 	computed.Write(body)
@@ -94,11 +99,13 @@ func (githubPayload *GitHubPayload) GetRepository(j map[string]interface{}, even
 			}
 		}
 	} else if event == "pull_request" {
+		// pull requests are keyed by the base repository, head one can be a
+		// fork or null if the fork was deleted
 		if j["pull_request"] != nil {
-			if j["pull_request"].(map[string]interface{})["head"] != nil {
-				if j["pull_request"].(map[string]interface{})["head"].(map[string]interface{})["repo"] != nil {
-					if j["pull_request"].(map[string]interface{})["head"].(map[string]interface{})["repo"].(map[string]interface{})["name"] != nil {
-						return j["pull_request"].(map[string]interface{})["head"].(map[string]interface{})["repo"].(map[string]interface{})["name"].(string)
+			if j["pull_request"].(map[string]interface{})["base"] != nil {
+				if j["pull_request"].(map[string]interface{})["base"].(map[string]interface{})["repo"] != nil {
+					if j["pull_request"].(map[string]interface{})["base"].(map[string]interface{})["repo"].(map[string]interface{})["name"] != nil {
+						return j["pull_request"].(map[string]interface{})["base"].(map[string]interface{})["repo"].(map[string]interface{})["name"].(string)
 					}
 				}
 			}
@@ -121,3 +128,81 @@ func (githubPayload *GitHubPayload) GetPullRequestNumber(j map[string]interface{
 	}
 	return 0
 }
+func (githubPayload *GitHubPayload) GetPullRequestDetails(j map[string]interface{}) *PullRequestDetails {
+	if j["pull_request"] != nil {
+		return newPullRequestDetailsFromJSON(j["pull_request"].(map[string]interface{}))
+	}
+	return &PullRequestDetails{Labels: []string{}}
+}
+
+// newPullRequestDetailsFromJSON reads pull request object which has the same
+// shape in webhook payloads and in GitHub API responses.
+func newPullRequestDetailsFromJSON(pr map[string]interface{}) *PullRequestDetails {
+	details := &PullRequestDetails{
+		Title:          getJSONString(pr, "title"),
+		Author:         getJSONString(pr, "user", "login"),
+		URL:            getJSONString(pr, "html_url"),
+		BaseBranch:     getJSONString(pr, "base", "ref"),
+		HeadSHA:        getJSONString(pr, "head", "sha"),
+		HeadRepository: getJSONString(pr, "head", "repo", "full_name"),
+		HeadCloneURL:   getJSONString(pr, "head", "repo", "clone_url"),
+		MergeableState: getJSONString(pr, "mergeable_state"),
+		Labels:         []string{},
+	}
+	// head repository is null when the fork has been deleted
+	baseRepository := getJSONString(pr, "base", "repo", "full_name")
+	details.Fork = details.HeadRepository != baseRepository
+	if pr["draft"] != nil {
+		details.Draft = pr["draft"].(bool)
+	}
+	if pr["labels"] != nil {
+		details.Labels = getLabelNames(pr["labels"].([]interface{}))
+	}
+	details.CreatedAt, _ = time.Parse(time.RFC3339, getJSONString(pr, "created_at"))
+	details.UpdatedAt, _ = time.Parse(time.RFC3339, getJSONString(pr, "updated_at"))
+	return details
+}
+
+// getJSONString walks nested objects and returns string value or empty string
+// when any of the keys is missing.
+func getJSONString(j map[string]interface{}, keys ...string) string {
+	for i, k := range keys {
+		if j[k] == nil {
+			return ""
+		}
+		if i == len(keys)-1 {
+			s, _ := j[k].(string)
+			return s
+		}
+		m, ok := j[k].(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		j = m
+	}
+	return ""
+}
+
+func getLabelNames(l []interface{}) []string {
+	labels := []string{}
+	for _, v := range l {
+		if v.(map[string]interface{})["name"] != nil {
+			labels = append(labels, v.(map[string]interface{})["name"].(string))
+		}
+	}
+	return labels
+}
+func (githubPayload *GitHubPayload) GetBaseBranchChangedFrom(j map[string]interface{}) string {
+	return getJSONString(j, "changes", "base", "ref", "from")
+}
+
+// GetBodyChangedFrom returns previous body of an edited pull request and
+// whether the body was changed at all.
+func (githubPayload *GitHubPayload) GetBodyChangedFrom(j map[string]interface{}) (string, bool) {
+	if j["changes"] != nil {
+		if j["changes"].(map[string]interface{})["body"] != nil {
+			return getJSONString(j, "changes", "body", "from"), true
+		}
+	}
+	return "", false
+}
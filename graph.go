@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	gocli "github.com/gen64/go-cli"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	GraphFormatDOT     = "dot"
+	GraphFormatMermaid = "mermaid"
+
+	GraphScopeAll          = "all"
+	GraphScopeComponent    = "component"
+	GraphScopeNeighborhood = "neighborhood"
+)
+
+type GraphNode struct {
+	Repository string
+	Number     int
+	Branch     string
+	Draft      bool
+	State      string
+}
+
+type GraphEdge struct {
+	From string
+	To   string
+}
+
+// Graph is a snapshot of the dependency graph. Edges point from a dependent
+// pull request to its dependency.
+type Graph struct {
+	Nodes map[string]*GraphNode
+	Edges []GraphEdge
+}
+
+func graphNodeKey(repo string, num int) string {
+	return fmt.Sprintf("%s#%d", repo, num)
+}
+
+func splitGraphNodeKey(key string) (string, int, error) {
+	vals := strings.Split(key, "#")
+	if len(vals) != 2 {
+		return "", 0, fmt.Errorf("Invalid pull request reference %s", key)
+	}
+	i, err := strconv.Atoi(vals[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid pull request number in %s", key)
+	}
+	return vals[0], i, nil
+}
+
+// NewGraphFromCache builds a graph from the cache. Caller must hold the cache
+// lock.
+func NewGraphFromCache(c *Cache) *Graph {
+	g := &Graph{
+		Nodes: map[string]*GraphNode{},
+		Edges: []GraphEdge{},
+	}
+	for repo, prs := range c.Branches {
+		for num, branch := range prs {
+			g.Nodes[graphNodeKey(repo, num)] = &GraphNode{
+				Repository: repo,
+				Number:     num,
+				Branch:     branch,
+				Draft:      c.IsDraft(repo, num),
+			}
+		}
+	}
+	for repo, prs := range c.Dependencies {
+		for num, deps := range prs {
+			for r, n := range deps {
+				g.addEdge(graphNodeKey(repo, num), graphNodeKey(r, n))
+			}
+		}
+	}
+	for _, node := range g.Nodes {
+		node.State = g.nodeState(graphNodeKey(node.Repository, node.Number))
+	}
+	return g
+}
+
+func (g *Graph) addEdge(from string, to string) {
+	for _, k := range []string{from, to} {
+		_, hasKey := g.Nodes[k]
+		if !hasKey {
+			r, n, err := splitGraphNodeKey(k)
+			if err != nil {
+				return
+			}
+			g.Nodes[k] = &GraphNode{Repository: r, Number: n}
+		}
+	}
+	g.Edges = append(g.Edges, GraphEdge{From: from, To: to})
+}
+
+// nodeState returns 'draft' for draft pull requests, 'waiting' for ones that
+// depend on open ones, 'ready' for ones that only have dependents or merged
+// dependencies and 'standalone' otherwise.
+func (g *Graph) nodeState(key string) string {
+	hasDeps := false
+	hasOpenDeps := false
+	hasDependents := false
+	for _, e := range g.Edges {
+		if e.From == key {
+			hasDeps = true
+			// merged dependencies are not cached and have no branch
+			if g.Nodes[e.To].Branch != "" {
+				hasOpenDeps = true
+			}
+		}
+		if e.To == key {
+			hasDependents = true
+		}
+	}
+	if g.Nodes[key].Branch == "" {
+		return "missing"
+	}
+	if g.Nodes[key].Draft {
+		return "draft"
+	}
+	if hasOpenDeps {
+		return "waiting"
+	}
+	if hasDeps || hasDependents {
+		return "ready"
+	}
+	return "standalone"
+}
+
+func (g *Graph) subgraph(keys map[string]bool) *Graph {
+	s := &Graph{
+		Nodes: map[string]*GraphNode{},
+		Edges: []GraphEdge{},
+	}
+	for k := range keys {
+		s.Nodes[k] = g.Nodes[k]
+	}
+	for _, e := range g.Edges {
+		if keys[e.From] && keys[e.To] {
+			s.Edges = append(s.Edges, e)
+		}
+	}
+	return s
+}
+
+// Component returns the connected component containing the pull request,
+// ignoring direction of the edges.
+func (g *Graph) Component(repo string, num int) (*Graph, error) {
+	start := graphNodeKey(repo, num)
+	_, hasKey := g.Nodes[start]
+	if !hasKey {
+		return nil, fmt.Errorf("Pull request %s not found", start)
+	}
+	keys := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Edges {
+			next := ""
+			if e.From == k {
+				next = e.To
+			} else if e.To == k {
+				next = e.From
+			}
+			if next != "" && !keys[next] {
+				keys[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return g.subgraph(keys), nil
+}
+
+// Components returns all connected components of the graph, sorted by their
+// first pull request.
+func (g *Graph) Components() []*Graph {
+	seen := map[string]bool{}
+	components := []*Graph{}
+	for _, k := range g.sortedKeys() {
+		if seen[k] {
+			continue
+		}
+		n := g.Nodes[k]
+		c, _ := g.Component(n.Repository, n.Number)
+		for ck := range c.Nodes {
+			seen[ck] = true
+		}
+		components = append(components, c)
+	}
+	return components
+}
+
+// TopologicalOrder returns pull requests so that every dependency comes
+// before its dependents.
+func (g *Graph) TopologicalOrder() ([]string, error) {
+	pending := map[string]int{}
+	for k := range g.Nodes {
+		pending[k] = 0
+	}
+	for _, e := range g.Edges {
+		pending[e.From]++
+	}
+
+	order := []string{}
+	for len(pending) > 0 {
+		ready := []string{}
+		for k, n := range pending {
+			if n == 0 {
+				ready = append(ready, k)
+			}
+		}
+		if len(ready) == 0 {
+			return nil, fmt.Errorf("Dependency cycle between pull requests")
+		}
+		sort.Strings(ready)
+		for _, k := range ready {
+			delete(pending, k)
+			for _, e := range g.Edges {
+				if e.To == k {
+					pending[e.From]--
+				}
+			}
+		}
+		order = append(order, ready...)
+	}
+	return order, nil
+}
+
+// Neighborhood returns the pull request with its direct dependencies and
+// dependents.
+func (g *Graph) Neighborhood(repo string, num int) (*Graph, error) {
+	start := graphNodeKey(repo, num)
+	_, hasKey := g.Nodes[start]
+	if !hasKey {
+		return nil, fmt.Errorf("Pull request %s not found", start)
+	}
+	keys := map[string]bool{start: true}
+	for _, e := range g.Edges {
+		if e.From == start {
+			keys[e.To] = true
+		} else if e.To == start {
+			keys[e.From] = true
+		}
+	}
+	return g.subgraph(keys), nil
+}
+
+// SortedNodes returns nodes sorted by repository and number.
+func (g *Graph) SortedNodes() []*GraphNode {
+	nodes := []*GraphNode{}
+	for _, k := range g.sortedKeys() {
+		nodes = append(nodes, g.Nodes[k])
+	}
+	return nodes
+}
+
+func (g *Graph) sortedKeys() []string {
+	keys := []string{}
+	for k := range g.Nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (g *Graph) sortedEdges() []GraphEdge {
+	edges := append([]GraphEdge{}, g.Edges...)
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From == edges[j].From {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].From < edges[j].From
+	})
+	return edges
+}
+
+func (g *Graph) nodeLabel(n *GraphNode) string {
+	label := graphNodeKey(n.Repository, n.Number)
+	if n.Branch != "" {
+		label += "\n" + n.Branch
+	}
+	return label
+}
+
+var graphDOTColors = map[string]string{
+	"standalone": "white",
+	"ready":      "palegreen",
+	"waiting":    "khaki",
+	"draft":      "lightblue",
+	"missing":    "lightgrey",
+}
+
+func (g *Graph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph pullrequests {\n")
+	sb.WriteString("  rankdir=LR;\n")
+	sb.WriteString("  node [shape=box, style=\"rounded,filled\"];\n")
+	for _, k := range g.sortedKeys() {
+		n := g.Nodes[k]
+		label := strings.ReplaceAll(g.nodeLabel(n), "\"", "\\\"")
+		label = strings.ReplaceAll(label, "\n", "\\n")
+		sb.WriteString(fmt.Sprintf("  \"%s\" [label=\"%s\", fillcolor=%s];\n", k, label, graphDOTColors[n.State]))
+	}
+	for _, e := range g.sortedEdges() {
+		sb.WriteString(fmt.Sprintf("  \"%s\" -> \"%s\";\n", e.From, e.To))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (g *Graph) Mermaid() string {
+	ids := map[string]string{}
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	for i, k := range g.sortedKeys() {
+		n := g.Nodes[k]
+		ids[k] = fmt.Sprintf("pr%d", i)
+		label := strings.ReplaceAll(g.nodeLabel(n), "#", "#35;")
+		label = strings.ReplaceAll(label, "\"", "#quot;")
+		label = strings.ReplaceAll(label, "\n", "<br/>")
+		sb.WriteString(fmt.Sprintf("  %s[\"%s\"]:::%s\n", ids[k], label, n.State))
+	}
+	for _, e := range g.sortedEdges() {
+		sb.WriteString(fmt.Sprintf("  %s --> %s\n", ids[e.From], ids[e.To]))
+	}
+	for _, state := range []string{"standalone", "ready", "waiting", "draft", "missing"} {
+		sb.WriteString(fmt.Sprintf("  classDef %s fill:%s\n", state, graphDOTColors[state]))
+	}
+	return sb.String()
+}
+
+// Render returns the graph in the given format.
+func (g *Graph) Render(format string) (string, error) {
+	switch format {
+	case GraphFormatDOT:
+		return g.DOT(), nil
+	case GraphFormatMermaid:
+		return g.Mermaid(), nil
+	}
+	return "", fmt.Errorf("Unsupported graph format %s", format)
+}
+
+func (app *App) getGraphFormat(r *http.Request) string {
+	format := r.URL.Query().Get("format")
+	if format != "" {
+		return format
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/vnd.graphviz") {
+		return GraphFormatDOT
+	}
+	if strings.Contains(accept, "text/vnd.mermaid") || strings.Contains(accept, "text/x-mermaid") {
+		return GraphFormatMermaid
+	}
+	return GraphFormatDOT
+}
+
+func (app *App) getGraph(scope string, repo string, num int) (*Graph, error) {
+	app.cache.mu.Lock()
+	g := NewGraphFromCache(&app.cache)
+	app.cache.mu.Unlock()
+
+	switch scope {
+	case "", GraphScopeAll:
+		return g, nil
+	case GraphScopeComponent:
+		return g.Component(repo, num)
+	case GraphScopeNeighborhood:
+		return g.Neighborhood(repo, num)
+	}
+	return nil, fmt.Errorf("Unsupported graph scope %s", scope)
+}
+
+func (app *App) apiHandlerGraph(w http.ResponseWriter, r *http.Request) {
+	if !app.checkAPIToken(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	num := 0
+	if q.Get("number") != "" {
+		i, err := strconv.Atoi(q.Get("number"))
+		if err != nil {
+			http.Error(w, "Invalid pull request number", http.StatusBadRequest)
+			return
+		}
+		num = i
+	}
+
+	switch q.Get("scope") {
+	case "", GraphScopeAll, GraphScopeComponent, GraphScopeNeighborhood:
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported graph scope %s", q.Get("scope")), http.StatusBadRequest)
+		return
+	}
+
+	g, err := app.getGraph(q.Get("scope"), q.Get("repository"), num)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	format := app.getGraphFormat(r)
+	out, err := g.Render(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	if format == GraphFormatMermaid {
+		w.Header().Set("content-type", "text/vnd.mermaid")
+	} else {
+		w.Header().Set("content-type", "text/vnd.graphviz")
+	}
+	w.Write([]byte(out))
+}
+
+func (app *App) graphHandler(cli *gocli.CLI) int {
+	c, err := ioutil.ReadFile(cli.Flag("config"))
+	if err != nil {
+		log.Fatal("Error reading config file")
+	}
+
+	var cfg Config
+	cfg.SetFromJSON(c)
+
+	u := cli.Flag("url")
+	if u == "" {
+		u = "http://localhost:" + cfg.Port
+	}
+
+	q := url.Values{}
+	q.Set("format", cli.Flag("format"))
+	if cli.Flag("format") == "" {
+		q.Set("format", GraphFormatDOT)
+	}
+	if cli.Flag("scope") != "" {
+		q.Set("scope", cli.Flag("scope"))
+		q.Set("repository", cli.Flag("repository"))
+		q.Set("number", cli.Flag("number"))
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(u, "/")+"/graph?"+q.Encode(), strings.NewReader(""))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating request: %s\n", err.Error())
+		return 1
+	}
+	if cfg.APITokenHeader != "" && cfg.APITokenValue != "" {
+		req.Header.Add(cfg.APITokenHeader, cfg.APITokenValue)
+	}
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching graph: %s\n", err.Error())
+		return 1
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Daemon returned HTTP %d: %s", resp.StatusCode, string(b))
+		return 1
+	}
+
+	fmt.Fprint(os.Stdout, string(b))
+	return 0
+}
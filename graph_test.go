@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNodeStateIgnoresMergedDependencies(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	openPullRequests(app, testPullRequest("repo-a", 1, "repo-b#2"), testPullRequest("repo-b", 2))
+	// merged dependencies stay in the cache as edges
+	app.wg.Add(1)
+	go app.updateCache("closed", "repo-b", 2, "", nil, []string{}, false)
+	app.wg.Wait()
+
+	g, err := app.getGraph(GraphScopeAll, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Nodes["repo-a#1"].State != "ready" {
+		t.Fatalf("expected repo-a#1 to be ready, got %s", g.Nodes["repo-a#1"].State)
+	}
+}
+
+func TestGraphRejectsUnsupportedScope(t *testing.T) {
+	app := newTestApp(t, "repo-a")
+	w := httptest.NewRecorder()
+	app.apiHandlerGraph(w, httptest.NewRequest("GET", "/api/graph?scope=everything", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	gocli "github.com/gen64/go-cli"
+	"log"
+	"sort"
+	"strings"
+)
+
+// webhookEvents are events the daemon processes.
+var webhookEvents = []string{
+	"check_run",
+	"check_suite",
+	"issue_comment",
+	"merge_group",
+	"pull_request",
+	"pull_request_review",
+	"repository",
+	"status",
+}
+
+const (
+	HooksActionSync  = "sync"
+	HooksActionCheck = "check"
+)
+
+// getDesiredHook returns webhook the daemon expects to receive events from.
+func (app *App) getDesiredHook() *Hook {
+	return &Hook{
+		URL:         app.cfg.Webhooks.URL,
+		ContentType: "json",
+		InsecureSSL: "0",
+		HasSecret:   app.cfg.Secret != "",
+		Events:      webhookEvents,
+		Active:      true,
+	}
+}
+
+// getHookMismatches returns differences between existing and desired
+// webhook. Secret value cannot be compared as GitHub does not return it.
+func getHookMismatches(hook *Hook, desired *Hook) []string {
+	mismatches := []string{}
+	if !hook.Active {
+		mismatches = append(mismatches, "not active")
+	}
+	if hook.ContentType != desired.ContentType {
+		mismatches = append(mismatches, fmt.Sprintf("content type is %s", hook.ContentType))
+	}
+	if hook.InsecureSSL != desired.InsecureSSL {
+		mismatches = append(mismatches, "SSL verification is disabled")
+	}
+	if desired.HasSecret && !hook.HasSecret {
+		mismatches = append(mismatches, "secret is not set")
+	}
+
+	events := map[string]bool{}
+	for _, e := range hook.Events {
+		events[e] = true
+	}
+	if !events["*"] {
+		missing := []string{}
+		for _, e := range desired.Events {
+			if !events[e] {
+				missing = append(missing, e)
+			}
+		}
+		if len(missing) > 0 {
+			mismatches = append(mismatches, "missing events "+strings.Join(missing, ", "))
+		}
+	}
+	return mismatches
+}
+
+// syncHook checks webhook on the organization when repo is empty or on the
+// repository, and creates or updates it when fix is true. It returns number
+// of problems found.
+func (app *App) syncHook(repo string, fix bool) (int, error) {
+	owner := app.cfg.PullRequestDependsOn.Owner
+	name := owner
+	if repo != "" {
+		name = owner + "/" + repo
+	}
+
+	hooks, err := app.githubAPI.GetHooks(owner, repo, app.getGitHubToken())
+	if err != nil {
+		return 0, fmt.Errorf("Error getting webhooks of %s: %s", name, err.Error())
+	}
+
+	desired := app.getDesiredHook()
+	var existing *Hook
+	for i := range hooks {
+		if hooks[i].URL == desired.URL {
+			existing = &hooks[i]
+			break
+		}
+	}
+
+	if existing == nil {
+		log.Print(fmt.Sprintf("Webhook of %s is missing", name))
+		if fix {
+			err = app.githubAPI.CreateHook(owner, repo, desired, app.cfg.Secret, app.getGitHubToken())
+			if err != nil {
+				return 1, err
+			}
+			log.Print(fmt.Sprintf("Webhook of %s created", name))
+		}
+		return 1, nil
+	}
+
+	mismatches := getHookMismatches(existing, desired)
+	if len(mismatches) == 0 {
+		log.Print(fmt.Sprintf("Webhook of %s is up to date", name))
+		// secret could have been changed in the config and GitHub does not
+		// return it, so it is written anyway
+		if !fix || app.cfg.Secret == "" {
+			return 0, nil
+		}
+	} else {
+		log.Print(fmt.Sprintf("Webhook of %s differs: %s", name, strings.Join(mismatches, "; ")))
+	}
+	if fix {
+		// events subscribed on top of the needed ones are kept
+		events := map[string]bool{}
+		for _, e := range append(existing.Events, desired.Events...) {
+			events[e] = true
+		}
+		desired.Events = []string{}
+		for e := range events {
+			desired.Events = append(desired.Events, e)
+		}
+		sort.Strings(desired.Events)
+		desired.ID = existing.ID
+
+		err = app.githubAPI.UpdateHook(owner, repo, desired, app.cfg.Secret, app.getGitHubToken())
+		if err != nil {
+			return len(mismatches), err
+		}
+		log.Print(fmt.Sprintf("Webhook of %s updated", name))
+	}
+	return len(mismatches), nil
+}
+
+// syncHooks checks webhook of the organization or of each of the
+// repositories. It returns number of problems found and number of webhooks
+// that could not be checked or fixed.
+func (app *App) syncHooks(repos []string, fix bool) (int, int) {
+	if app.cfg.Webhooks.URL == "" {
+		log.Print("Webhook URL is not set in the config")
+		return 0, 1
+	}
+	if app.cfg.Webhooks.Organization {
+		repos = []string{""}
+	}
+	problems := 0
+	failures := 0
+	for _, repo := range repos {
+		n, err := app.syncHook(repo, fix)
+		problems += n
+		if err != nil {
+			log.Print(err.Error())
+			failures++
+		}
+	}
+	return problems, failures
+}
+
+func (app *App) validateHooksAction(cli *gocli.CLI) error {
+	if cli.Arg("action") != HooksActionSync && cli.Arg("action") != HooksActionCheck {
+		return errors.New("Action has to be sync or check")
+	}
+	return nil
+}
+
+func (app *App) hooksHandler(cli *gocli.CLI) int {
+	app.loadConfig(cli.Flag("config"))
+
+	repos := []string{}
+	if !app.cfg.Webhooks.Organization {
+		all, err := app.githubAPI.GetRepositoriesList(app.cfg.PullRequestDependsOn.Owner, app.cfg.PullRequestDependsOn.Organization, app.getGitHubToken())
+		if err != nil {
+			log.Print("Error fetching repository list from GitHub: " + err.Error())
+			return 1
+		}
+		for _, repo := range all {
+			if app.checkIfRepoShouldBeIncluded(repo) {
+				repos = append(repos, repo)
+			}
+		}
+	}
+
+	problems, failures := app.syncHooks(repos, cli.Arg("action") == HooksActionSync)
+	if failures > 0 || (problems > 0 && cli.Arg("action") == HooksActionCheck) {
+		return 1
+	}
+	return 0
+}
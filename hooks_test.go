@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSyncHookWritesSecret(t *testing.T) {
+	secret := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/owner1/repo-a/hooks":
+			hook := map[string]interface{}{
+				"id":     1,
+				"active": true,
+				"events": webhookEvents,
+				"config": map[string]string{
+					"url":          "https://example.com/hook",
+					"content_type": "json",
+					"insecure_ssl": "0",
+					"secret":       "********",
+				},
+			}
+			b, _ := json.Marshal([]interface{}{hook})
+			w.Write(b)
+		case r.Method == "PATCH" && r.URL.Path == "/repos/owner1/repo-a/hooks/1":
+			b, _ := ioutil.ReadAll(r.Body)
+			var j map[string]interface{}
+			json.Unmarshal(b, &j)
+			secret = getJSONString(j, "config", "secret")
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	app := newTestApp(t, "repo-a")
+	app.githubAPI.BaseURL = srv.URL
+	app.cfg.Webhooks.URL = "https://example.com/hook"
+	app.cfg.Secret = "new-secret"
+
+	problems, err := app.syncHook("repo-a", false)
+	if err != nil || problems != 0 || secret != "" {
+		t.Fatalf("expected check to find nothing and not update, got %d, %v, %q", problems, err, secret)
+	}
+	problems, err = app.syncHook("repo-a", true)
+	if err != nil || problems != 0 {
+		t.Fatalf("unexpected result %d, %v", problems, err)
+	}
+	if secret != "new-secret" {
+		t.Fatalf("expected secret to be written, got %q", secret)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// getDesiredLabels returns managed labels mapped to whether the pull request
+// should have them. Caller must hold the cache lock.
+func (app *App) getDesiredLabels(repo string, num int) map[string]bool {
+	blocked := app.cache.IsBlocked(repo, num)
+	hasDeps := len(app.cache.Dependencies[repo][num]) > 0
+	return map[string]bool{
+		app.cfg.Labels.HasDependencies:   hasDeps,
+		app.cfg.Labels.Blocked:           blocked,
+		app.cfg.Labels.DependencyOf:      len(app.cache.Dependents[repo][num]) > 0,
+		app.cfg.Labels.DependenciesReady: hasDeps && !blocked,
+	}
+}
+
+// syncLabels adds and removes managed labels so they reflect the dependency
+// graph. Cached labels are updated straight away, requests to GitHub are made
+// in the background. Caller must hold the cache lock.
+func (app *App) syncLabels(repo string, num int) {
+	if !app.cfg.Labels.Enabled {
+		return
+	}
+	details := app.cache.PullRequests[repo][num]
+	if details == nil {
+		return
+	}
+
+	toAdd := []string{}
+	toRemove := []string{}
+	labels := []string{}
+	desired := app.getDesiredLabels(repo, num)
+	for _, l := range details.Labels {
+		want, managed := desired[l]
+		if managed && !want {
+			toRemove = append(toRemove, l)
+			continue
+		}
+		labels = append(labels, l)
+	}
+	for l, want := range desired {
+		if want && !details.HasLabel(l) {
+			toAdd = append(toAdd, l)
+			labels = append(labels, l)
+		}
+	}
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		return
+	}
+
+	updated := *details
+	updated.Labels = labels
+	app.cache.PullRequests[repo][num] = &updated
+
+	owner := app.cfg.PullRequestDependsOn.Owner
+	go func() {
+		if len(toAdd) > 0 {
+			log.Print(fmt.Sprintf("Adding labels %v to %s/%s#%d", toAdd, owner, repo, num))
+			err := app.githubAPI.AddLabels(owner, repo, num, toAdd, app.getGitHubToken())
+			if err != nil {
+				log.Print(err.Error())
+			}
+		}
+		for _, l := range toRemove {
+			log.Print(fmt.Sprintf("Removing label %s from %s/%s#%d", l, owner, repo, num))
+			err := app.githubAPI.RemoveLabel(owner, repo, num, l, app.getGitHubToken())
+			if err != nil {
+				log.Print(err.Error())
+			}
+		}
+	}()
+}
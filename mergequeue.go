@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// merge group head refs look like gh-readonly-queue/main/pr-123-<sha>
+var mergeGroupRefRegexp = regexp.MustCompile(`^(refs/heads/)?gh-readonly-queue/.+/pr-([0-9]+)-[0-9a-f]+$`)
+
+func getMergeGroupPullRequestNumber(ref string) (int, error) {
+	m := mergeGroupRefRegexp.FindStringSubmatch(ref)
+	if m == nil {
+		return 0, fmt.Errorf("Unable to get pull request number from %s", ref)
+	}
+	return strconv.Atoi(m[2])
+}
+
+// checkMergeGroup returns dependencies of the pull request that are neither
+// merged nor ahead of it in the merge queue. Caller must hold the cache lock.
+func (app *App) checkMergeGroup(repo string, num int) []string {
+	ahead := map[int]bool{}
+	for _, n := range app.cache.MergeQueue[repo] {
+		if n == num {
+			break
+		}
+		ahead[n] = true
+	}
+
+	missing := []string{}
+	for _, k := range dependencyList(app.cache.Dependencies[repo][num]) {
+		r, n, _ := splitGraphNodeKey(k)
+		_, isOpen := app.cache.Branches[r][n]
+		if !isOpen {
+			continue
+		}
+		// merge queues are per repository so other repositories have to be
+		// merged already
+		if r == repo && ahead[n] {
+			continue
+		}
+		missing = append(missing, k)
+	}
+	return missing
+}
+
+// updateMergeQueue adds or removes a pull request from the merge queue and
+// returns dependencies that are not merged or ahead in the queue.
+func (app *App) updateMergeQueue(action string, repo string, num int) []string {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	// positions of the pull requests behind it change as well
+	affected := []string{graphNodeKey(repo, num)}
+	queue := []int{}
+	for _, n := range app.cache.MergeQueue[repo] {
+		affected = append(affected, graphNodeKey(repo, n))
+		if n != num {
+			queue = append(queue, n)
+		}
+	}
+	defer app.cache.bumpRevision(app.cache.snapshot(affected))
+
+	if action == "checks_requested" {
+		queue = append(queue, num)
+	}
+	app.cache.MergeQueue[repo] = queue
+
+	return app.checkMergeGroup(repo, num)
+}
+
+// processPayloadOnMergeGroup sets a status on the merge group commit so the
+// queue rejects pull requests merged before their dependencies. The status
+// context has to be required by the branch protection for that to work.
+func (app *App) processPayloadOnMergeGroup(j map[string]interface{}, event string) error {
+	repo := getJSONString(j, "repository", "name")
+	if repo == "" || !app.checkIfRepoShouldBeIncluded(repo) {
+		return nil
+	}
+	action := getJSONString(j, "action")
+	sha := getJSONString(j, "merge_group", "head_sha")
+	num, err := getMergeGroupPullRequestNumber(getJSONString(j, "merge_group", "head_ref"))
+	if err != nil {
+		return err
+	}
+
+	log.Print(fmt.Sprintf("Got merge group %s for %s#%d", action, repo, num))
+
+	missing := app.updateMergeQueue(action, repo, num)
+	if action != "checks_requested" {
+		return nil
+	}
+
+	state := StatusSuccess
+	description := "Dependencies are merged or ahead in the queue"
+	if len(missing) > 0 {
+		state = StatusFailure
+		description = "Dependencies not merged nor ahead in the queue: " + strings.Join(missing, ", ")
+	}
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+
+	owner := app.cfg.PullRequestDependsOn.Owner
+	context := app.cfg.Statuses.GetContext("merge-queue")
+	go func() {
+		err := app.githubAPI.CreateStatus(owner, repo, sha, state, context, description, app.getGitHubToken())
+		if err != nil {
+			log.Print(fmt.Sprintf("Error setting %s status on %s/%s@%s: %s", context, owner, repo, sha, err.Error()))
+		}
+	}()
+	return nil
+}
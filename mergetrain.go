@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MergeTrainCar is a single pull request to be merged by the train.
+type MergeTrainCar struct {
+	Repository string
+	Number     int
+	HeadSHA    string
+}
+
+func (car *MergeTrainCar) String() string {
+	return graphNodeKey(car.Repository, car.Number)
+}
+
+// MergeTrains keeps track of groups that are being merged so a second train
+// is not started for the same pull requests.
+type MergeTrains struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func NewMergeTrains() *MergeTrains {
+	return &MergeTrains{
+		running: map[string]bool{},
+	}
+}
+
+func (trains *MergeTrains) start(cars []MergeTrainCar) bool {
+	trains.mu.Lock()
+	defer trains.mu.Unlock()
+
+	for _, car := range cars {
+		if trains.running[car.String()] {
+			return false
+		}
+	}
+	for _, car := range cars {
+		trains.running[car.String()] = true
+	}
+	return true
+}
+
+func (trains *MergeTrains) finish(cars []MergeTrainCar) {
+	trains.mu.Lock()
+	defer trains.mu.Unlock()
+
+	for _, car := range cars {
+		delete(trains.running, car.String())
+	}
+}
+
+// planMergeTrain returns pull requests from the connected group in
+// dependency order, or an error listing the ones that are not ready.
+func (app *App) planMergeTrain(repo string, num int) ([]MergeTrainCar, error) {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	g, err := NewGraphFromCache(&app.cache).Component(repo, num)
+	if err != nil {
+		return nil, err
+	}
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	cars := []MergeTrainCar{}
+	problems := []string{}
+	for _, k := range order {
+		r, n, _ := splitGraphNodeKey(k)
+		_, isOpen := app.cache.Branches[r][n]
+		if !isOpen {
+			// merged in the meantime
+			continue
+		}
+		details := app.cache.PullRequests[r][n]
+		if details == nil || details.HeadSHA == "" {
+			problems = append(problems, k+" has no known head commit")
+			continue
+		}
+		if details.Draft {
+			problems = append(problems, k+" is a draft")
+		}
+		if !app.cache.IsApproved(r, n) {
+			problems = append(problems, k+" is not approved")
+		}
+		ci := app.cache.GetCIState(r, n)
+		if ci != StatusSuccess {
+			if ci == "" {
+				ci = "unknown"
+			}
+			problems = append(problems, fmt.Sprintf("%s CI is %s", k, ci))
+		}
+		cars = append(cars, MergeTrainCar{
+			Repository: r,
+			Number:     n,
+			HeadSHA:    details.HeadSHA,
+		})
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, ", "))
+	}
+	return cars, nil
+}
+
+// waitForPullRequest polls GitHub until fn returns true for the pull request
+// or the configured timeout passes.
+func (app *App) waitForPullRequest(car *MergeTrainCar, fn func(details *PullRequestDetails, merged bool) bool) error {
+	deadline := time.Now().Add(time.Second * time.Duration(app.cfg.MergeTrain.Timeout))
+	for {
+		details, merged, err := app.githubAPI.GetPullRequest(app.cfg.PullRequestDependsOn.Owner, car.Repository, car.Number, app.getGitHubToken())
+		if err == nil && fn(details, merged) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("Timed out waiting for %s", car.String())
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (app *App) mergeCar(car *MergeTrainCar) error {
+	// mergeable state is computed by GitHub in the background
+	var state string
+	err := app.waitForPullRequest(car, func(details *PullRequestDetails, merged bool) bool {
+		state = details.MergeableState
+		if merged {
+			state = "merged"
+		}
+		return state != "unknown" && state != ""
+	})
+	if err != nil {
+		return err
+	}
+	if state == "merged" {
+		return nil
+	}
+	if state != "clean" && state != "has_hooks" && state != "unstable" {
+		return fmt.Errorf("%s is not mergeable, its state is %s", car.String(), state)
+	}
+
+	log.Print(fmt.Sprintf("Merge train: merging %s", car.String()))
+	err = app.githubAPI.MergePullRequest(app.cfg.PullRequestDependsOn.Owner, car.Repository, car.Number, car.HeadSHA, app.cfg.MergeTrain.MergeMethod, app.getGitHubToken())
+	if err != nil {
+		return err
+	}
+
+	return app.waitForPullRequest(car, func(details *PullRequestDetails, merged bool) bool {
+		return merged
+	})
+}
+
+func (app *App) comment(repo string, num int, body string) {
+	err := app.githubAPI.CreateComment(app.cfg.PullRequestDependsOn.Owner, repo, num, body, app.getGitHubToken())
+	if err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (app *App) runMergeTrain(repo string, num int, cars []MergeTrainCar) {
+	defer app.mergeTrains.finish(cars)
+
+	merged := []string{}
+	for i := range cars {
+		err := app.mergeCar(&cars[i])
+		if err != nil {
+			log.Print(fmt.Sprintf("Merge train aborted on %s: %s", cars[i].String(), err.Error()))
+			msg := fmt.Sprintf("Merge train aborted on %s: %s", cars[i].String(), err.Error())
+			if len(merged) > 0 {
+				msg += "\n\nAlready merged: " + strings.Join(merged, ", ")
+			}
+			app.comment(repo, num, msg)
+			if cars[i].Repository != repo || cars[i].Number != num {
+				app.comment(cars[i].Repository, cars[i].Number, msg)
+			}
+			return
+		}
+		merged = append(merged, cars[i].String())
+	}
+
+	log.Print(fmt.Sprintf("Merge train finished: %s", strings.Join(merged, ", ")))
+	app.comment(repo, num, "Merge train finished, merged: "+strings.Join(merged, ", "))
+}
+
+// startMergeTrain checks the group of the pull request and starts merging it
+// in the background.
+func (app *App) startMergeTrain(repo string, num int) {
+	if !app.cfg.MergeTrain.Enabled {
+		return
+	}
+
+	// label would start the train again when the pull request gets updated
+	go func() {
+		err := app.githubAPI.RemoveLabel(app.cfg.PullRequestDependsOn.Owner, repo, num, app.cfg.MergeTrain.Label, app.getGitHubToken())
+		if err != nil {
+			log.Print(err.Error())
+		}
+	}()
+
+	cars, err := app.planMergeTrain(repo, num)
+	if err != nil {
+		go app.comment(repo, num, "Merge train not started: "+err.Error())
+		return
+	}
+	if !app.mergeTrains.start(cars) {
+		go app.comment(repo, num, "Merge train not started: another train is already merging this group")
+		return
+	}
+
+	names := []string{}
+	for _, car := range cars {
+		names = append(names, car.String())
+	}
+	log.Print(fmt.Sprintf("Merge train started by %s: %s", graphNodeKey(repo, num), strings.Join(names, ", ")))
+	go app.runMergeTrain(repo, num, cars)
+}
+
+func (app *App) processPayloadOnMergeTrainLabel(j map[string]interface{}, event string) error {
+	repo := app.githubPayload.GetRepository(j, event)
+	if repo == "" || !app.checkIfRepoShouldBeIncluded(repo) {
+		return nil
+	}
+	if app.githubPayload.GetAction(j, event) != "labeled" || getJSONString(j, "label", "name") != app.cfg.MergeTrain.Label {
+		return nil
+	}
+
+	app.startMergeTrain(repo, int(app.githubPayload.GetPullRequestNumber(j)))
+	return nil
+}
+
+// mergeTrainAssociations are author associations allowed to start a merge
+// train with a comment.
+var mergeTrainAssociations = map[string]bool{
+	"OWNER":        true,
+	"MEMBER":       true,
+	"COLLABORATOR": true,
+}
+
+func (app *App) processPayloadOnIssueComment(j map[string]interface{}, event string) error {
+	repo := getJSONString(j, "repository", "name")
+	if repo == "" || !app.checkIfRepoShouldBeIncluded(repo) {
+		return nil
+	}
+	if getJSONString(j, "action") != "created" || j["issue"] == nil {
+		return nil
+	}
+	if j["issue"].(map[string]interface{})["pull_request"] == nil || j["issue"].(map[string]interface{})["number"] == nil {
+		return nil
+	}
+	body := strings.TrimSpace(getJSONString(j, "comment", "body"))
+	if strings.TrimSpace(strings.Split(body, "\n")[0]) != app.cfg.MergeTrain.Command {
+		return nil
+	}
+
+	num := int(j["issue"].(map[string]interface{})["number"].(float64))
+	// anyone can comment on public repositories
+	author := getJSONString(j, "comment", "user", "login")
+	association := getJSONString(j, "comment", "author_association")
+	if !mergeTrainAssociations[association] {
+		log.Print(fmt.Sprintf("Merge train command on %s ignored: %s is %s", graphNodeKey(repo, num), author, association))
+		return nil
+	}
+	app.startMergeTrain(repo, num)
+	return nil
+}
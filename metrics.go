@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiHandlerMetrics exports counters in the Prometheus text format.
+func (app *App) apiHandlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if !app.checkAPIToken(w, r) {
+		return
+	}
+
+	var b strings.Builder
+	limit, remaining, reset := app.githubAPI.Transport.RateLimit()
+	if remaining >= 0 {
+		b.WriteString("# HELP pullrequestd_github_rate_limit_remaining Remaining GitHub API requests in the current window.\n")
+		b.WriteString("# TYPE pullrequestd_github_rate_limit_remaining gauge\n")
+		b.WriteString(fmt.Sprintf("pullrequestd_github_rate_limit_remaining %d\n", remaining))
+		b.WriteString("# HELP pullrequestd_github_rate_limit_limit GitHub API requests allowed in the current window.\n")
+		b.WriteString("# TYPE pullrequestd_github_rate_limit_limit gauge\n")
+		b.WriteString(fmt.Sprintf("pullrequestd_github_rate_limit_limit %d\n", limit))
+		b.WriteString("# HELP pullrequestd_github_rate_limit_reset_timestamp_seconds Time when the GitHub API quota is reset.\n")
+		b.WriteString("# TYPE pullrequestd_github_rate_limit_reset_timestamp_seconds gauge\n")
+		b.WriteString(fmt.Sprintf("pullrequestd_github_rate_limit_reset_timestamp_seconds %d\n", reset.Unix()))
+	}
+
+	notModified, retries := app.githubAPI.Transport.Counters()
+	b.WriteString("# HELP pullrequestd_github_not_modified_total GitHub API requests answered from the ETag cache.\n")
+	b.WriteString("# TYPE pullrequestd_github_not_modified_total counter\n")
+	b.WriteString(fmt.Sprintf("pullrequestd_github_not_modified_total %d\n", notModified))
+	b.WriteString("# HELP pullrequestd_github_retries_total Retried GitHub API requests.\n")
+	b.WriteString("# TYPE pullrequestd_github_retries_total counter\n")
+	b.WriteString(fmt.Sprintf("pullrequestd_github_retries_total %d\n", retries))
+
+	b.WriteString("# HELP pullrequestd_bootstrap_failed Repositories that failed to bootstrap and are being retried.\n")
+	b.WriteString("# TYPE pullrequestd_bootstrap_failed gauge\n")
+	for _, repo := range app.getBootstrapFailed() {
+		b.WriteString(fmt.Sprintf("pullrequestd_bootstrap_failed{repository=%q} 1\n", repo))
+	}
+
+	corrections, lastRun := app.getReconcileCorrections()
+	b.WriteString("# HELP pullrequestd_reconcile_corrections_total Cache corrections made by the reconciler.\n")
+	b.WriteString("# TYPE pullrequestd_reconcile_corrections_total counter\n")
+	for _, kind := range []string{CorrectionOpened, CorrectionClosed, CorrectionDependencies, CorrectionDetails} {
+		b.WriteString(fmt.Sprintf("pullrequestd_reconcile_corrections_total{kind=%q} %d\n", kind, corrections[kind]))
+	}
+	if !lastRun.IsZero() {
+		b.WriteString("# HELP pullrequestd_reconcile_last_run_timestamp_seconds Time of the last reconciliation.\n")
+		b.WriteString("# TYPE pullrequestd_reconcile_last_run_timestamp_seconds gauge\n")
+		b.WriteString(fmt.Sprintf("pullrequestd_reconcile_last_run_timestamp_seconds %d\n", lastRun.Unix()))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+// PullRequestState is everything known about a single pull request, returned
+// by the /pulls/{repository}/{number} endpoint.
+type PullRequestState struct {
+	Repository           string              `json:"repository"`
+	Number               int                 `json:"number"`
+	Branch               string              `json:"branch"`
+	Details              *PullRequestDetails `json:"details"`
+	Dependencies         []string            `json:"dependencies"`
+	Dependents           []string            `json:"dependents"`
+	Blocked              bool                `json:"blocked"`
+	Draft                bool                `json:"draft"`
+	DraftDependencies    []string            `json:"draft_dependencies"`
+	BaseBranchPolicy     string              `json:"base_branch_policy"`
+	BaseBranchMismatches []string            `json:"base_branch_mismatches"`
+	CI                   string              `json:"ci"`
+	CombinedCI           *CombinedCI         `json:"combined_ci"`
+	Approved             bool                `json:"approved"`
+	CombinedApproval     *CombinedApproval   `json:"combined_approval"`
+}
+
+// getPullRequestState returns state of the pull request or nil if it is not
+// cached. Caller must hold the cache lock.
+func (app *App) getPullRequestState(repo string, num int) *PullRequestState {
+	branch, hasKey := app.cache.Branches[repo][num]
+	if !hasKey {
+		return nil
+	}
+	return &PullRequestState{
+		Repository:           repo,
+		Number:               num,
+		Branch:               branch,
+		Details:              app.cache.PullRequests[repo][num],
+		Dependencies:         dependencyList(app.cache.Dependencies[repo][num]),
+		Dependents:           dependencyList(app.cache.Dependents[repo][num]),
+		Blocked:              app.cache.IsBlocked(repo, num),
+		Draft:                app.cache.IsDraft(repo, num),
+		DraftDependencies:    app.cache.DraftDependencies(repo, num),
+		BaseBranchPolicy:     app.getBaseBranchPolicy(),
+		BaseBranchMismatches: app.getBaseBranchMismatches(repo, num),
+		CI:                   app.cache.GetCIState(repo, num),
+		CombinedCI:           app.GetCombinedCI(repo, num),
+		Approved:             app.cache.IsApproved(repo, num),
+		CombinedApproval:     app.GetCombinedApproval(repo, num),
+	}
+}
+
+func (app *App) apiHandlerPullRequest(w http.ResponseWriter, r *http.Request) {
+	if !app.checkAPIToken(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	num, err := strconv.Atoi(vars["number"])
+	if err != nil {
+		http.Error(w, "Invalid pull request number", http.StatusBadRequest)
+		return
+	}
+
+	app.cache.mu.Lock()
+	state := app.getPullRequestState(vars["repository"], num)
+	var b []byte
+	if state != nil {
+		b, err = json.Marshal(state)
+	}
+	app.cache.mu.Unlock()
+
+	if state == nil {
+		http.Error(w, "Pull request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CorrectionOpened       = "opened"
+	CorrectionClosed       = "closed"
+	CorrectionDependencies = "dependencies"
+	CorrectionDetails      = "details"
+)
+
+// getRepositories returns sorted names of the tracked repositories.
+func (app *App) getRepositories() []string {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	repos := []string{}
+	for repo := range app.repositories {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+func (app *App) isRepositoryTracked(repo string) bool {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+	return app.repositories[repo]
+}
+
+func (app *App) setRepositories(repos []string) {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	app.repositories = map[string]bool{}
+	for _, repo := range repos {
+		app.repositories[repo] = true
+	}
+}
+
+// detailsChanged returns true when details that are shown or used for
+// decisions differ.
+func detailsChanged(cached *PullRequestDetails, details *PullRequestDetails) bool {
+	if cached == nil {
+		return true
+	}
+	return cached.HeadSHA != details.HeadSHA ||
+		cached.Draft != details.Draft ||
+		cached.BaseBranch != details.BaseBranch ||
+		cached.Title != details.Title ||
+		strings.Join(cached.Labels, ",") != strings.Join(details.Labels, ",")
+}
+
+// getExpectedDependencies returns sorted dependencies from the DependsOn lines
+// as updateCache would set them: open pull requests and merged ones that are
+// already cached as dependencies. Caller must hold the cache lock.
+func (app *App) getExpectedDependencies(repo string, num int, dependsOn []string) []string {
+	// dependencies are keyed by repository, the last line wins
+	deps := map[string]int{}
+	for _, dep := range app.resolveDependsOn(dependsOn) {
+		r, n, err := splitGraphNodeKey(dep)
+		if err != nil {
+			continue
+		}
+		_, isOpen := app.cache.Branches[r][n]
+		if isOpen || app.cache.Dependencies[repo][num][r] == n {
+			deps[r] = n
+		}
+	}
+	return dependencyList(deps)
+}
+
+func (app *App) addCorrections(corrections map[string]int) {
+	app.reconcileMu.Lock()
+	defer app.reconcileMu.Unlock()
+
+	for kind, n := range corrections {
+		app.reconcileCorrections[kind] += uint64(n)
+	}
+	app.reconcileLastRun = time.Now()
+}
+
+// getReconcileCorrections returns number of corrections per kind and time of
+// the last reconciliation.
+func (app *App) getReconcileCorrections() (map[string]uint64, time.Time) {
+	app.reconcileMu.Lock()
+	defer app.reconcileMu.Unlock()
+
+	corrections := map[string]uint64{}
+	for kind, n := range app.reconcileCorrections {
+		corrections[kind] = n
+	}
+	return corrections, app.reconcileLastRun
+}
+
+// reconcileRepository compares open pull requests on GitHub with the cache
+// and applies differences the same way webhooks would.
+func (app *App) reconcileRepository(repo string, pullRequests []PullRequest, listedAt time.Time, corrections map[string]int) {
+	listed := map[int]bool{}
+	isNew := map[int]bool{}
+	opened := []PullRequest{}
+	for _, pr := range pullRequests {
+		listed[pr.Number] = true
+
+		app.cache.mu.Lock()
+		_, isOpen := app.cache.Branches[repo][pr.Number]
+		app.cache.mu.Unlock()
+		if !isOpen {
+			log.Print(fmt.Sprintf("Reconciler: %s is open but missing in cache", graphNodeKey(repo, pr.Number)))
+			opened = append(opened, pr)
+			isNew[pr.Number] = true
+			corrections[CorrectionOpened]++
+		}
+	}
+	app.applyPullRequests(opened, true)
+
+	// list cut by the page limit does not say anything about the rest
+	complete := app.githubAPI.MaxPages == 0 || len(pullRequests) < app.githubAPI.MaxPages*100
+
+	app.cache.mu.Lock()
+	closed := map[int][]string{}
+	for num := range app.cache.Branches[repo] {
+		details := app.cache.PullRequests[repo][num]
+		// pull request could have been opened after the list was fetched
+		if !listed[num] && complete && (details == nil || details.UpdatedAt.Before(listedAt)) {
+			// dependencies are passed so their dependents get cleaned up
+			closed[num] = dependencyList(app.cache.Dependencies[repo][num])
+		}
+	}
+	app.cache.mu.Unlock()
+	for num, deps := range closed {
+		log.Print(fmt.Sprintf("Reconciler: %s is not open anymore", graphNodeKey(repo, num)))
+		app.wg.Add(1)
+		go app.updateCache("closed", repo, num, "", nil, deps, false)
+		app.wg.Wait()
+		corrections[CorrectionClosed]++
+	}
+
+	for _, pr := range pullRequests {
+		app.cache.mu.Lock()
+		cached := app.cache.PullRequests[repo][pr.Number]
+		branch := app.cache.Branches[repo][pr.Number]
+		before := dependencyList(app.cache.Dependencies[repo][pr.Number])
+		after := app.getExpectedDependencies(repo, pr.Number, pr.DependsOn)
+		app.cache.mu.Unlock()
+
+		// webhook could have arrived after the list was fetched
+		if cached != nil && cached.UpdatedAt.After(pr.Details.UpdatedAt) {
+			continue
+		}
+
+		if isNew[pr.Number] {
+			app.applyPullRequests([]PullRequest{pr}, false)
+			app.reconcileKeys(getPullRequestKeys([]PullRequest{pr}))
+			continue
+		}
+
+		if strings.Join(before, ",") != strings.Join(after, ",") {
+			log.Print(fmt.Sprintf("Reconciler: dependencies of %s changed from %v to %v", graphNodeKey(repo, pr.Number), before, after))
+			app.wg.Add(1)
+			go app.updateCache("edited", repo, pr.Number, pr.Branch, pr.Details, pr.DependsOn, false)
+			app.wg.Wait()
+			corrections[CorrectionDependencies]++
+			continue
+		}
+
+		if branch != pr.Branch || detailsChanged(cached, pr.Details) {
+			log.Print(fmt.Sprintf("Reconciler: details of %s changed", graphNodeKey(repo, pr.Number)))
+			app.updateDetails(repo, pr.Number, pr.Branch, pr.Details)
+			corrections[CorrectionDetails]++
+		}
+	}
+}
+
+// reconcileWithGitHub re-lists open pull requests of tracked repositories and
+// repairs the cache when webhooks were missed.
+func (app *App) reconcileWithGitHub() {
+	failed := map[string]bool{}
+	for _, repo := range app.getBootstrapFailed() {
+		failed[repo] = true
+	}
+
+	corrections := map[string]int{}
+	for _, repo := range app.getRepositories() {
+		// these are retried by the bootstrap
+		if failed[repo] {
+			continue
+		}
+		listedAt := time.Now()
+		pullRequests, err := app.githubAPI.GetPullRequestList(app.cfg.PullRequestDependsOn.Owner, repo, app.getGitHubToken())
+		if err != nil {
+			log.Print(fmt.Sprintf("Reconciler: error fetching pull requests for %s: %s", repo, err.Error()))
+			continue
+		}
+		app.reconcileRepository(repo, pullRequests, listedAt, corrections)
+	}
+
+	app.addCorrections(corrections)
+	if len(corrections) == 0 {
+		log.Print("Reconciler: cache is in sync with GitHub")
+		return
+	}
+	kinds := []string{}
+	for kind, n := range corrections {
+		kinds = append(kinds, fmt.Sprintf("%s=%d", kind, n))
+	}
+	sort.Strings(kinds)
+	log.Print("Reconciler: made corrections " + strings.Join(kinds, " "))
+}
+
+// startReconciler periodically reconciles the cache with GitHub when the
+// interval is set.
+func (app *App) startReconciler() {
+	if app.cfg.GitHub.ReconcileInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(app.cfg.GitHub.ReconcileInterval) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			app.reconcileWithGitHub()
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileKeepsMergedDependency(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	dependency := testPullRequest("repo-a", 1)
+	dependent := testPullRequest("repo-b", 2, "repo-a#1")
+	openPullRequests(app, dependency, dependent)
+
+	app.wg.Add(1)
+	go app.updateCache("closed", "repo-a", 1, "", nil, []string{}, false)
+	app.wg.Wait()
+
+	if !app.cache.IsReady("repo-b", 2) {
+		t.Fatal("repo-b#2 should be ready once its dependency merged")
+	}
+
+	corrections := map[string]int{}
+	app.reconcileRepository("repo-b", []PullRequest{dependent}, time.Now(), corrections)
+	if len(corrections) > 0 {
+		t.Fatalf("expected no corrections, got %v", corrections)
+	}
+	if app.cache.Dependencies["repo-b"][2]["repo-a"] != 1 {
+		t.Fatalf("merged dependency was dropped: %v", app.cache.Dependencies["repo-b"][2])
+	}
+	if !app.cache.IsReady("repo-b", 2) {
+		t.Fatal("repo-b#2 should stay ready after reconcile")
+	}
+}
+
+func TestReconcileFixesMissedDependency(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	dependency := testPullRequest("repo-a", 1)
+	dependent := testPullRequest("repo-b", 2)
+	openPullRequests(app, dependency, dependent)
+
+	// body edit adding the dependency was missed
+	edited := testPullRequest("repo-b", 2, "repo-a#1")
+	edited.Details.UpdatedAt = edited.Details.UpdatedAt.Add(time.Hour)
+	corrections := map[string]int{}
+	app.reconcileRepository("repo-b", []PullRequest{edited}, time.Now(), corrections)
+	if corrections[CorrectionDependencies] != 1 {
+		t.Fatalf("expected dependencies correction, got %v", corrections)
+	}
+	if app.cache.Dependents["repo-a"][1]["repo-b"] != 2 {
+		t.Fatalf("dependent edge missing: %v", app.cache.Dependents)
+	}
+}
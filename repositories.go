@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// RenameRepository moves pull requests of a repository to its new name and
+// rewrites dependency edges pointing to it. Caller must hold the cache lock.
+func (c *Cache) RenameRepository(from string, to string) {
+	if v, hasKey := c.Branches[from]; hasKey {
+		c.Branches[to] = v
+		delete(c.Branches, from)
+	}
+	if v, hasKey := c.Dependencies[from]; hasKey {
+		c.Dependencies[to] = v
+		delete(c.Dependencies, from)
+	}
+	if v, hasKey := c.Dependents[from]; hasKey {
+		c.Dependents[to] = v
+		delete(c.Dependents, from)
+	}
+	if v, hasKey := c.JenkinsTriggers[from]; hasKey {
+		c.JenkinsTriggers[to] = v
+		delete(c.JenkinsTriggers, from)
+	}
+	if v, hasKey := c.PullRequests[from]; hasKey {
+		c.PullRequests[to] = v
+		delete(c.PullRequests, from)
+	}
+	if v, hasKey := c.Reviews[from]; hasKey {
+		c.Reviews[to] = v
+		delete(c.Reviews, from)
+	}
+	if v, hasKey := c.MergeQueue[from]; hasKey {
+		c.MergeQueue[to] = v
+		delete(c.MergeQueue, from)
+	}
+
+	// edges are keyed by repository name
+	for _, prs := range []map[string]map[int]map[string]int{c.Dependencies, c.Dependents} {
+		for _, edges := range prs {
+			for _, m := range edges {
+				if n, hasKey := m[from]; hasKey {
+					m[to] = n
+					delete(m, from)
+				}
+			}
+		}
+	}
+}
+
+// addRepositoryAlias makes DependsOn lines with the old name resolve to the
+// new one. Caller must hold the cache lock.
+func (app *App) addRepositoryAlias(from string, to string) {
+	for old, current := range app.repositoryAliases {
+		if current == from {
+			app.repositoryAliases[old] = to
+		}
+	}
+	app.repositoryAliases[from] = to
+	// renaming back makes the name valid again
+	delete(app.repositoryAliases, to)
+}
+
+// resolveDependsOn replaces old names of renamed repositories in DependsOn
+// lines with the current ones. Caller must hold the cache lock.
+func (app *App) resolveDependsOn(dependsOn []string) []string {
+	resolved := []string{}
+	for _, dep := range dependsOn {
+		r, n, err := splitGraphNodeKey(dep)
+		if err == nil && app.repositoryAliases[r] != "" {
+			dep = graphNodeKey(app.repositoryAliases[r], n)
+		}
+		resolved = append(resolved, dep)
+	}
+	return resolved
+}
+
+// resolveRenamedRepositories asks GitHub for current names of repositories
+// mentioned in DependsOn lines that are not tracked, so that pull requests
+// depending on a repository renamed before startup are not dropped.
+func (app *App) resolveRenamedRepositories(pulls map[string][]PullRequest) {
+	unknown := map[string]bool{}
+	app.cache.mu.Lock()
+	for _, pullRequests := range pulls {
+		for _, pr := range pullRequests {
+			for _, dep := range app.resolveDependsOn(pr.DependsOn) {
+				r, _, err := splitGraphNodeKey(dep)
+				if err == nil && !app.repositories[r] {
+					unknown[r] = true
+				}
+			}
+		}
+	}
+	app.cache.mu.Unlock()
+
+	for r := range unknown {
+		name, err := app.githubAPI.GetRepositoryName(app.cfg.PullRequestDependsOn.Owner, r, app.getGitHubToken())
+		if err != nil || name == "" || name == r {
+			continue
+		}
+		app.cache.mu.Lock()
+		if app.repositories[name] {
+			log.Print(fmt.Sprintf("Repository %s/%s in DependsOn lines is now %s", app.cfg.PullRequestDependsOn.Owner, r, name))
+			app.addRepositoryAlias(r, name)
+		}
+		app.cache.mu.Unlock()
+	}
+}
+
+// getRepositoryKeys returns pull requests of the repository together with
+// their dependencies and dependents. Caller must hold the cache lock.
+func (app *App) getRepositoryKeys(repo string) []string {
+	keys := []string{}
+	for n := range app.cache.Branches[repo] {
+		keys = append(keys, graphNodeKey(repo, n))
+		keys = append(keys, dependencyList(app.cache.Dependencies[repo][n])...)
+		keys = append(keys, dependencyList(app.cache.Dependents[repo][n])...)
+	}
+	return keys
+}
+
+// trackRepository starts tracking a repository that was created or moved
+// into the owner.
+func (app *App) trackRepository(repo string) {
+	app.cache.mu.Lock()
+	if app.repositories[repo] {
+		app.cache.mu.Unlock()
+		return
+	}
+	app.repositories[repo] = true
+	// a new repository can take the name of a renamed one
+	delete(app.repositoryAliases, repo)
+	app.cache.mu.Unlock()
+	log.Print(fmt.Sprintf("Started tracking repository %s/%s", app.cfg.PullRequestDependsOn.Owner, repo))
+
+	if app.cfg.Webhooks.Sync && !app.cfg.Webhooks.Organization {
+		_, err := app.syncHook(repo, true)
+		if err != nil {
+			log.Print(err.Error())
+		}
+	}
+
+	pullRequests, err := app.fetchPullRequestList(repo)
+	if err != nil {
+		app.addBootstrapFailed(map[string]string{repo: err.Error()})
+		return
+	}
+	app.addPullRequests(pullRequests)
+	app.refreshDependentsOf(repo)
+}
+
+// dropRepository stops tracking a repository and removes its pull requests
+// as if they were closed.
+func (app *App) dropRepository(repo string) {
+	// dependencies are passed so their dependents get cleaned up
+	app.cache.mu.Lock()
+	deps := map[int][]string{}
+	for n := range app.cache.Branches[repo] {
+		deps[n] = dependencyList(app.cache.Dependencies[repo][n])
+	}
+	app.cache.mu.Unlock()
+
+	for n, d := range deps {
+		app.wg.Add(1)
+		go app.updateCache("closed", repo, n, "", nil, d, false)
+		app.wg.Wait()
+	}
+
+	app.cache.mu.Lock()
+	// pull requests elsewhere cannot depend on the repository anymore
+	changed := []string{}
+	for r, prs := range app.cache.Dependencies {
+		for n, deps := range prs {
+			if _, hasKey := deps[repo]; hasKey && r != repo {
+				changed = append(changed, graphNodeKey(r, n))
+			}
+		}
+	}
+	before := app.cache.snapshot(changed)
+	for _, k := range changed {
+		r, n, _ := splitGraphNodeKey(k)
+		delete(app.cache.Dependencies[r][n], repo)
+	}
+	app.cache.bumpRevision(before)
+	app.afterCacheUpdate(changed)
+
+	delete(app.repositories, repo)
+	delete(app.cache.Branches, repo)
+	delete(app.cache.Dependencies, repo)
+	delete(app.cache.Dependents, repo)
+	delete(app.cache.JenkinsTriggers, repo)
+	delete(app.cache.PullRequests, repo)
+	delete(app.cache.Reviews, repo)
+	delete(app.cache.MergeQueue, repo)
+	app.cache.mu.Unlock()
+
+	app.removeBootstrapFailed(repo)
+	log.Print(fmt.Sprintf("Stopped tracking repository %s/%s", app.cfg.PullRequestDependsOn.Owner, repo))
+}
+
+// renameRepository rewrites cache of a renamed repository and then tracks or
+// drops it depending on whether the new name matches the config. Cache is
+// rewritten before returning so that events on the new name that follow are
+// not mixed with the old one.
+func (app *App) renameRepository(from string, to string) {
+	app.cache.mu.Lock()
+	if !app.repositories[from] {
+		app.addRepositoryAlias(from, to)
+		app.cache.mu.Unlock()
+		if app.checkIfRepoShouldBeIncluded(to) {
+			go app.trackRepository(to)
+		}
+		return
+	}
+
+	keys := app.getRepositoryKeys(from)
+	for n := range app.cache.Branches[from] {
+		keys = append(keys, graphNodeKey(to, n))
+	}
+	before := app.cache.snapshot(keys)
+
+	// statuses are remembered by repository name
+	for _, details := range app.cache.PullRequests[from] {
+		app.forgetPublishedStatuses(from, details.HeadSHA)
+	}
+	app.cache.RenameRepository(from, to)
+	owner := app.cfg.PullRequestDependsOn.Owner
+	for _, details := range app.cache.PullRequests[to] {
+		if details.HeadRepository == owner+"/"+from {
+			details.HeadRepository = owner + "/" + to
+		}
+	}
+	delete(app.repositories, from)
+	app.repositories[to] = true
+	// DependsOn lines in pull request bodies keep the old name
+	app.addRepositoryAlias(from, to)
+	for k, conditions := range app.skippedTriggers {
+		r, n, err := splitGraphNodeKey(k)
+		if err == nil && r == from {
+			app.skippedTriggers[graphNodeKey(to, n)] = conditions
+			delete(app.skippedTriggers, k)
+		}
+	}
+
+	app.cache.bumpRevision(before)
+	// statuses mention dependencies by name
+	app.afterCacheUpdate(app.getRepositoryKeys(to))
+	app.cache.mu.Unlock()
+
+	app.bootstrapMu.Lock()
+	if err, hasKey := app.bootstrapFailed[from]; hasKey {
+		app.bootstrapFailed[to] = err
+		delete(app.bootstrapFailed, from)
+	}
+	app.bootstrapMu.Unlock()
+
+	log.Print(fmt.Sprintf("Repository %s/%s renamed to %s", owner, from, to))
+	if !app.checkIfRepoShouldBeIncluded(to) {
+		app.dropRepository(to)
+	}
+}
+
+func (app *App) processPayloadOnRepository(j map[string]interface{}, event string) error {
+	action := getJSONString(j, "action")
+	repo := getJSONString(j, "repository", "name")
+	if repo == "" {
+		return nil
+	}
+	inOwner := getJSONString(j, "repository", "owner", "login") == app.cfg.PullRequestDependsOn.Owner
+
+	log.Print(fmt.Sprintf("Got repository %s for %s", action, repo))
+
+	switch action {
+	case "created", "unarchived":
+		if inOwner && app.checkIfRepoShouldBeIncluded(repo) {
+			go app.trackRepository(repo)
+		}
+	case "archived", "deleted":
+		if app.isRepositoryTracked(repo) {
+			app.dropRepository(repo)
+		}
+	case "renamed":
+		from := getJSONString(j, "changes", "repository", "name", "from")
+		if from != "" && inOwner {
+			app.renameRepository(from, repo)
+		}
+	case "transferred":
+		if !inOwner {
+			if app.isRepositoryTracked(repo) {
+				app.dropRepository(repo)
+			}
+		} else if app.checkIfRepoShouldBeIncluded(repo) {
+			go app.trackRepository(repo)
+		}
+	}
+	return nil
+}
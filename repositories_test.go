@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenamedRepositoryIsRewrittenBeforeHandlerReturns(t *testing.T) {
+	app := newTestApp(t, "repo-a", "repo-b")
+	openPullRequests(app, testPullRequest("repo-a", 1), testPullRequest("repo-b", 2, "repo-a#1"))
+
+	err := app.processPayloadOnRepository(map[string]interface{}{
+		"action": "renamed",
+		"repository": map[string]interface{}{
+			"name":  "repo-c",
+			"owner": map[string]interface{}{"login": "owner1"},
+		},
+		"changes": map[string]interface{}{
+			"repository": map[string]interface{}{
+				"name": map[string]interface{}{"from": "repo-a"},
+			},
+		},
+	}, "repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+	if _, hasKey := app.cache.Branches["repo-c"][1]; !hasKey {
+		t.Fatalf("expected repo-c#1 to be cached")
+	}
+	if _, hasKey := app.cache.Branches["repo-a"]; hasKey {
+		t.Fatalf("expected repo-a to be gone")
+	}
+	if app.cache.Dependencies["repo-b"][2]["repo-c"] != 1 {
+		t.Fatalf("expected repo-b#2 to depend on repo-c#1, got %v", app.cache.Dependencies["repo-b"][2])
+	}
+}
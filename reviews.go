@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	ReviewApproved         = "approved"
+	ReviewChangesRequested = "changes_requested"
+	ReviewCommented        = "commented"
+	ReviewDismissed        = "dismissed"
+)
+
+// setReviewState updates latest review state of a reviewer. Comments do not
+// change the state and dismissed reviews no longer count.
+func setReviewState(reviews map[string]string, user string, state string) {
+	if user == "" {
+		return
+	}
+	switch state {
+	case ReviewApproved, ReviewChangesRequested:
+		reviews[user] = state
+	case ReviewDismissed:
+		delete(reviews, user)
+	}
+}
+
+// IsApproved returns true when pull request has at least one approval and no
+// changes requested. Caller must hold the cache lock.
+func (c *Cache) IsApproved(repo string, num int) bool {
+	approved := false
+	for _, state := range c.Reviews[repo][num] {
+		if state == ReviewChangesRequested {
+			return false
+		}
+		if state == ReviewApproved {
+			approved = true
+		}
+	}
+	return approved
+}
+
+type CombinedApproval struct {
+	Approved bool     `json:"approved"`
+	Missing  []string `json:"missing"`
+}
+
+// GetCombinedApproval checks approval of the pull request and all its
+// transitive dependencies. Caller must hold the cache lock.
+func (app *App) GetCombinedApproval(repo string, num int) *CombinedApproval {
+	combined := &CombinedApproval{
+		Missing: []string{},
+	}
+	keys := append([]string{graphNodeKey(repo, num)}, app.cache.TransitiveDependencies(repo, num)...)
+	for _, k := range keys {
+		r, n, _ := splitGraphNodeKey(k)
+		if !app.cache.IsApproved(r, n) {
+			combined.Missing = append(combined.Missing, k)
+		}
+	}
+	combined.Approved = len(combined.Missing) == 0
+	return combined
+}
+
+// checkCombinedApproval publishes the combined review status. Caller must
+// hold the cache lock.
+func (app *App) checkCombinedApproval(repo string, num int) {
+	if !app.cfg.Statuses.AggregateReviews {
+		return
+	}
+	combined := app.GetCombinedApproval(repo, num)
+	if combined.Approved {
+		app.setCommitStatus(repo, num, "reviews", StatusSuccess, "Pull request and its dependencies are approved")
+		return
+	}
+	app.setCommitStatus(repo, num, "reviews", StatusPending, "Missing approval: "+strings.Join(combined.Missing, ", "))
+}
+
+// setReviews replaces reviews of a pull request. Caller must hold the cache
+// lock.
+func (app *App) setReviews(repo string, num int, reviews map[string]string) {
+	_, hasKey := app.cache.Reviews[repo]
+	if !hasKey {
+		app.cache.Reviews[repo] = map[int]map[string]string{}
+	}
+	app.cache.Reviews[repo][num] = reviews
+}
+
+// fetchReviews gets current reviews from GitHub, used when bootstrapping.
+func (app *App) fetchReviews(repo string, num int) {
+	reviews, err := app.githubAPI.GetPullRequestReviews(app.cfg.PullRequestDependsOn.Owner, repo, num, app.getGitHubToken())
+	if err != nil {
+		log.Print(fmt.Sprintf("Error fetching reviews for %s#%d: %s", repo, num, err.Error()))
+		return
+	}
+
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+	app.setReviews(repo, num, reviews)
+}
+
+func (app *App) processPayloadOnPullRequestReview(j map[string]interface{}, event string) error {
+	repo := getJSONString(j, "repository", "name")
+	if repo == "" || !app.checkIfRepoShouldBeIncluded(repo) {
+		return nil
+	}
+	if j["pull_request"] == nil || j["pull_request"].(map[string]interface{})["number"] == nil {
+		return nil
+	}
+	num := int(j["pull_request"].(map[string]interface{})["number"].(float64))
+	action := getJSONString(j, "action")
+	user := getJSONString(j, "review", "user", "login")
+	state := strings.ToLower(getJSONString(j, "review", "state"))
+	if action == "dismissed" {
+		state = ReviewDismissed
+	}
+
+	log.Print(fmt.Sprintf("Got review %s by %s on %s#%d", state, user, repo, num))
+
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	_, isOpen := app.cache.Branches[repo][num]
+	if !isOpen {
+		return nil
+	}
+
+	affected := []string{graphNodeKey(repo, num)}
+	defer app.cache.bumpRevision(app.cache.snapshot(affected))
+
+	reviews := map[string]string{}
+	for u, s := range app.cache.Reviews[repo][num] {
+		reviews[u] = s
+	}
+	setReviewState(reviews, user, state)
+	app.setReviews(repo, num, reviews)
+
+	app.afterCacheUpdate(affected)
+	return nil
+}
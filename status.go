@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusPending = "pending"
+	StatusError   = "error"
+)
+
+// setCommitStatus publishes a commit status on the head of the pull request
+// unless the same one has already been published. Caller must hold the cache
+// lock, the request to GitHub is made in the background.
+func (app *App) setCommitStatus(repo string, num int, name string, state string, description string) {
+	if !app.cfg.Statuses.Enabled {
+		return
+	}
+	details := app.cache.PullRequests[repo][num]
+	if details == nil || details.HeadSHA == "" {
+		return
+	}
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+
+	context := app.cfg.Statuses.GetContext(name)
+	key := fmt.Sprintf("%s@%s|%s", repo, details.HeadSHA, context)
+	if app.publishedStatuses[key] == state+"|"+description {
+		return
+	}
+	app.publishedStatuses[key] = state + "|" + description
+
+	owner := app.cfg.PullRequestDependsOn.Owner
+	sha := details.HeadSHA
+	go func() {
+		err := app.githubAPI.CreateStatus(owner, repo, sha, state, context, description, app.getGitHubToken())
+		if err != nil {
+			log.Print(fmt.Sprintf("Error setting %s status on %s/%s@%s: %s", context, owner, repo, sha, err.Error()))
+		}
+	}()
+}
+
+// forgetPublishedStatuses drops statuses remembered for a commit that is not
+// a head of the pull request anymore. Caller must hold the cache lock.
+func (app *App) forgetPublishedStatuses(repo string, sha string) {
+	prefix := fmt.Sprintf("%s@%s|", repo, sha)
+	for k := range app.publishedStatuses {
+		if strings.HasPrefix(k, prefix) {
+			delete(app.publishedStatuses, k)
+		}
+	}
+}
+
+// afterCacheUpdate re-evaluates checks for pull requests affected by a cache
+// update. Caller must hold the cache lock.
+func (app *App) afterCacheUpdate(keys []string) {
+	// aggregated checks depend on the whole chain of dependencies
+	all := append([]string{}, keys...)
+	for _, k := range keys {
+		r, n, err := splitGraphNodeKey(k)
+		if err == nil {
+			all = append(all, app.cache.TransitiveDependents(r, n)...)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, k := range all {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if app.quietKeys[k] > 0 {
+			continue
+		}
+		r, n, err := splitGraphNodeKey(k)
+		if err != nil {
+			continue
+		}
+		_, isOpen := app.cache.Branches[r][n]
+		if !isOpen {
+			continue
+		}
+		app.checkBaseBranchPolicy(r, n)
+		app.checkDependencies(r, n)
+		app.checkCombinedCI(r, n)
+		app.checkCombinedApproval(r, n)
+		app.syncLabels(r, n)
+	}
+}
+
+// reconcileAll re-evaluates labels and statuses of all cached pull requests.
+func (app *App) reconcileAll() {
+	app.cache.mu.Lock()
+	keys := []string{}
+	for r, prs := range app.cache.Branches {
+		for n := range prs {
+			keys = append(keys, graphNodeKey(r, n))
+		}
+	}
+	app.cache.mu.Unlock()
+	app.reconcileKeys(keys)
+}
+
+// reconcileKeys re-evaluates labels and statuses of the pull requests and
+// everything that depends on them.
+func (app *App) reconcileKeys(keys []string) {
+	app.cache.mu.Lock()
+	defer app.cache.mu.Unlock()
+
+	defer app.cache.bumpRevision(app.cache.snapshot(keys))
+	app.afterCacheUpdate(keys)
+}
+
+// checkDependencies publishes a status saying whether the pull request still
+// waits for its dependencies. Caller must hold the cache lock.
+func (app *App) checkDependencies(repo string, num int) {
+	suffix := ""
+	if app.cache.IsDraft(repo, num) {
+		suffix = " (draft)"
+	}
+
+	drafts := app.cache.BlockingDrafts(repo, num)
+	if len(drafts) > 0 {
+		app.setCommitStatus(repo, num, "dependencies", StatusFailure, "Depends on draft pull requests: "+strings.Join(drafts, ", ")+suffix)
+		return
+	}
+
+	open := []string{}
+	for _, k := range dependencyList(app.cache.Dependencies[repo][num]) {
+		r, n, _ := splitGraphNodeKey(k)
+		_, isOpen := app.cache.Branches[r][n]
+		if isOpen {
+			open = append(open, k)
+		}
+	}
+	if len(open) > 0 {
+		app.setCommitStatus(repo, num, "dependencies", StatusPending, "Waiting for open pull requests: "+strings.Join(open, ", ")+suffix)
+		return
+	}
+	app.setCommitStatus(repo, num, "dependencies", StatusSuccess, "All dependencies are merged"+suffix)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublishedStatusesArePruned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	app := newTestApp(t, "repo-a")
+	app.githubAPI.BaseURL = srv.URL
+	app.cfg.Statuses.Enabled = true
+	openPullRequests(app, testPullRequest("repo-a", 1))
+	if len(app.publishedStatuses) == 0 {
+		t.Fatalf("expected statuses to be published")
+	}
+
+	details := *app.cache.PullRequests["repo-a"][1]
+	details.HeadSHA = "repo-a-sha2"
+	app.updateDetails("repo-a", 1, "branch-repo-a", &details)
+	for k := range app.publishedStatuses {
+		if !strings.HasPrefix(k, "repo-a@repo-a-sha2|") {
+			t.Fatalf("expected statuses of the previous head to be dropped, got %s", k)
+		}
+	}
+
+	app.wg.Add(1)
+	go app.updateCache("closed", "repo-a", 1, "", nil, []string{}, false)
+	app.wg.Wait()
+	if len(app.publishedStatuses) != 0 {
+		t.Fatalf("expected no statuses after closing, got %v", app.publishedStatuses)
+	}
+}